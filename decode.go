@@ -0,0 +1,474 @@
+package jsparser
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DecodeError describes a value which can't be stored in the target Go value
+type DecodeError struct {
+	Path  string       // path of the value inside the decoded element, like a.b[2]
+	Value string       // kind of the json value
+	Type  reflect.Type // target Go type
+	Err   error        // underlying error, if any
+}
+
+func (e *DecodeError) Error() string {
+
+	msg := fmt.Sprintf("Cannot decode json %s into Go value of type %v", e.Value, e.Type)
+	if e.Path != "" {
+		msg += " at " + e.Path
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// prefix adds the property name or [index] of the parent value to the path
+func (e *DecodeError) prefix(seg string) {
+
+	if e.Path == "" || e.Path[0] == '[' {
+		e.Path = seg + e.Path
+	} else {
+		e.Path = seg + "." + e.Path
+	}
+
+}
+
+// Decode stores the element in the value pointed to by v. Struct fields are
+// matched with their json tag or name like encoding/json does. Pointers,
+// slices, arrays, maps, nested structs, interface{} and types implementing
+// encoding.TextUnmarshaler, time.Time among them, are supported.
+func (element *JSON) Decode(v interface{}) error {
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("Decode needs a non nil pointer, got %T", v)
+	}
+	return decodeValue(element, rv.Elem())
+
+}
+
+// StreamInto decodes every result of the parser into a new T. Decode errors
+// are yielded with the result they belong to, parse errors end the iteration.
+func StreamInto[T any](j *JsonParser) iter.Seq2[T, error] {
+
+	return func(yield func(T, error) bool) {
+		for json, err := range j.All() {
+			var v T
+			if err == nil {
+				err = json.Decode(&v)
+			}
+			if !yield(v, err) {
+				return
+			}
+		}
+	}
+
+}
+
+func decodeValue(src interface{}, dst reflect.Value) error {
+
+	if node, ok := src.(*JSON); ok {
+		switch node.ValueType {
+		case Invalid:
+			if node.Err != nil {
+				return node.Err
+			}
+			return decodeError(src, dst, nil)
+		case Null:
+			src = nil
+		case String:
+			src = node.StringVal
+		case Number:
+			src = json.Number(node.StringVal)
+		case Boolean:
+			src = node.BoolVal
+		}
+	}
+
+	if src == nil { // null clears pointers, maps, slices and interfaces and leaves the rest
+		switch dst.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+			dst.Set(reflect.Zero(dst.Type()))
+		}
+		return nil
+	}
+
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return decodeValue(src, dst.Elem())
+	}
+
+	if dst.CanAddr() {
+		if u, ok := dst.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			s, ok := src.(string)
+			if !ok {
+				return decodeError(src, dst, nil)
+			}
+			if err := u.UnmarshalText([]byte(s)); err != nil {
+				return decodeError(src, dst, err)
+			}
+			return nil
+		}
+	}
+
+	switch dst.Kind() {
+	case reflect.Interface:
+
+		if dst.NumMethod() != 0 {
+			return decodeError(src, dst, nil)
+		}
+		dst.Set(reflect.ValueOf(toInterface(src)))
+
+	case reflect.Struct:
+
+		return decodeStruct(src, dst)
+
+	case reflect.Map:
+
+		return decodeMap(src, dst)
+
+	case reflect.Slice, reflect.Array:
+
+		return decodeArray(src, dst)
+
+	case reflect.String:
+
+		s, ok := src.(string)
+		if !ok {
+			return decodeError(src, dst, nil)
+		}
+		dst.SetString(s)
+
+	case reflect.Bool:
+
+		b, ok := src.(bool)
+		if !ok {
+			return decodeError(src, dst, nil)
+		}
+		dst.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+
+		s, ok := formatNumber(src)
+		if !ok {
+			return decodeError(src, dst, nil)
+		}
+		i, err := strconv.ParseInt(s, 10, dst.Type().Bits())
+		if err != nil {
+			return decodeError(src, dst, err)
+		}
+		dst.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+
+		s, ok := formatNumber(src)
+		if !ok {
+			return decodeError(src, dst, nil)
+		}
+		u, err := strconv.ParseUint(s, 10, dst.Type().Bits())
+		if err != nil {
+			return decodeError(src, dst, err)
+		}
+		dst.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+
+		s, ok := formatNumber(src)
+		if !ok {
+			return decodeError(src, dst, nil)
+		}
+		f, err := strconv.ParseFloat(s, dst.Type().Bits())
+		if err != nil {
+			return decodeError(src, dst, err)
+		}
+		dst.SetFloat(f)
+
+	default:
+
+		return decodeError(src, dst, nil)
+
+	}
+
+	return nil
+
+}
+
+func decodeStruct(src interface{}, dst reflect.Value) error {
+
+	obj, ok := src.(*JSON)
+	if !ok || obj.ValueType != Object {
+		return decodeError(src, dst, nil)
+	}
+
+	fields := cachedFields(dst.Type())
+
+	for key, value := range obj.ObjectVals {
+
+		f, ok := fields.exact[key]
+		if !ok {
+			if f, ok = fields.folded[strings.ToLower(key)]; !ok {
+				continue
+			}
+		}
+
+		fv := fieldByIndex(dst, f.index)
+		if !fv.IsValid() || !fv.CanSet() {
+			continue
+		}
+
+		if err := decodeValue(value, fv); err != nil {
+			if de, ok := err.(*DecodeError); ok {
+				de.prefix(key)
+			}
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func decodeMap(src interface{}, dst reflect.Value) error {
+
+	obj, ok := src.(*JSON)
+	if !ok || obj.ValueType != Object {
+		return decodeError(src, dst, nil)
+	}
+
+	t := dst.Type()
+	if dst.IsNil() {
+		dst.Set(reflect.MakeMapWithSize(t, len(obj.ObjectVals)))
+	}
+
+	for key, value := range obj.ObjectVals {
+
+		kv := reflect.New(t.Key()).Elem()
+		switch t.Key().Kind() {
+		case reflect.String:
+			kv.SetString(key)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i, err := strconv.ParseInt(key, 10, t.Key().Bits())
+			if err != nil {
+				return decodeError(src, dst, err)
+			}
+			kv.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u, err := strconv.ParseUint(key, 10, t.Key().Bits())
+			if err != nil {
+				return decodeError(src, dst, err)
+			}
+			kv.SetUint(u)
+		default:
+			return decodeError(src, dst, nil)
+		}
+
+		ev := reflect.New(t.Elem()).Elem()
+		if err := decodeValue(value, ev); err != nil {
+			if de, ok := err.(*DecodeError); ok {
+				de.prefix(key)
+			}
+			return err
+		}
+		dst.SetMapIndex(kv, ev)
+	}
+
+	return nil
+
+}
+
+func decodeArray(src interface{}, dst reflect.Value) error {
+
+	arr, ok := src.(*JSON)
+	if !ok || arr.ValueType != Array {
+		return decodeError(src, dst, nil)
+	}
+
+	n := len(arr.ArrayVals)
+	if dst.Kind() == reflect.Slice {
+		dst.Set(reflect.MakeSlice(dst.Type(), n, n))
+	} else if n > dst.Len() {
+		n = dst.Len()
+	}
+
+	for i := 0; i < n; i++ {
+		if err := decodeValue(arr.ArrayVals[i], dst.Index(i)); err != nil {
+			if de, ok := err.(*DecodeError); ok {
+				de.prefix("[" + strconv.Itoa(i) + "]")
+			}
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// toInterface converts a value to maps, slices and scalars for interface{} targets
+func toInterface(src interface{}) interface{} {
+
+	node, ok := src.(*JSON)
+	if !ok {
+		return src
+	}
+
+	switch node.ValueType {
+	case Object:
+		m := make(map[string]interface{}, len(node.ObjectVals))
+		for k, v := range node.ObjectVals {
+			m[k] = toInterface(v)
+		}
+		return m
+	case Array:
+		a := make([]interface{}, len(node.ArrayVals))
+		for i, v := range node.ArrayVals {
+			a[i] = toInterface(v)
+		}
+		return a
+	case String:
+		return node.StringVal
+	case Number:
+		return json.Number(node.StringVal)
+	case Boolean:
+		return node.BoolVal
+	}
+	return nil
+
+}
+
+func decodeError(src interface{}, dst reflect.Value, err error) error {
+
+	kind := "value"
+	switch v := src.(type) {
+	case *JSON:
+		switch v.ValueType {
+		case Object:
+			kind = "object"
+		case Array:
+			kind = "array"
+		}
+	case string:
+		kind = "string " + strconv.Quote(v)
+	case bool:
+		kind = "boolean"
+	default:
+		if n, ok := formatNumber(v); ok {
+			kind = "number " + n
+		}
+	}
+
+	return &DecodeError{Value: kind, Type: dst.Type(), Err: err}
+
+}
+
+// decodeField is a struct field a json property can be decoded into
+type decodeField struct {
+	name  string
+	index []int
+}
+
+type structFields struct {
+	exact  map[string]*decodeField
+	folded map[string]*decodeField // lower case names for case insensitive matching
+}
+
+var fieldCache sync.Map // reflect.Type -> *structFields
+
+func cachedFields(t reflect.Type) *structFields {
+
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+
+	fields := &structFields{exact: map[string]*decodeField{}, folded: map[string]*decodeField{}}
+	for _, f := range typeFields(t, nil) {
+		f := f
+		if _, ok := fields.exact[f.name]; !ok {
+			fields.exact[f.name] = &f
+		}
+		if _, ok := fields.folded[strings.ToLower(f.name)]; !ok {
+			fields.folded[strings.ToLower(f.name)] = &f
+		}
+	}
+
+	f, _ := fieldCache.LoadOrStore(t, fields)
+	return f.(*structFields)
+
+}
+
+// typeFields lists the fields of t, fields of embedded structs after the
+// fields of t so that the outer ones win on name conflicts
+func typeFields(t reflect.Type, index []int) []decodeField {
+
+	var fields, embedded []decodeField
+
+	for i := 0; i < t.NumField(); i++ {
+
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		idx := append(append([]int{}, index...), i)
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, typeFields(ft, idx)...)
+				continue
+			}
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, decodeField{name: name, index: idx})
+	}
+
+	return append(fields, embedded...)
+
+}
+
+// fieldByIndex returns the field allocating nil embedded pointers on the way.
+// The zero Value is returned for fields behind unexported embedded pointers.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+
+}
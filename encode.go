@@ -0,0 +1,242 @@
+package jsparser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// MarshalJSON returns the element as compact json. Object properties are
+// written in the order of Keys and numbers as they were in the input.
+func (element *JSON) MarshalJSON() ([]byte, error) {
+
+	var buf bytes.Buffer
+	e := &encoder{w: &buf}
+	if err := e.value(element, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+
+}
+
+// MarshalIndent is like MarshalJSON but each property and element starts on a
+// new line beginning with prefix and one copy of indent per nesting level
+func (element *JSON) MarshalIndent(prefix, indent string) ([]byte, error) {
+
+	var buf bytes.Buffer
+	e := &encoder{w: &buf, prefix: prefix, indent: indent}
+	if err := e.value(element, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+
+}
+
+// WriteTo writes the element to w as compact json
+func (element *JSON) WriteTo(w io.Writer) (int64, error) {
+	return element.WriteIndentTo(w, "", "")
+}
+
+// WriteIndentTo writes the element to w indented like MarshalIndent
+func (element *JSON) WriteIndentTo(w io.Writer, prefix, indent string) (int64, error) {
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	e := &encoder{w: bw, prefix: prefix, indent: indent}
+
+	err := e.value(element, 0)
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	return cw.n, err
+
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// bufWriter is implemented by *bufio.Writer and *bytes.Buffer
+type bufWriter interface {
+	io.Writer
+	io.ByteWriter
+	io.StringWriter
+}
+
+type encoder struct {
+	w      bufWriter
+	prefix string
+	indent string
+}
+
+// value writes a *JSON or a value of ObjectVals and ArrayVals
+func (e *encoder) value(v interface{}, depth int) error {
+
+	switch val := v.(type) {
+	case nil:
+		e.w.WriteString("null")
+	case string:
+		e.string(val)
+	case bool:
+		if val {
+			e.w.WriteString("true")
+		} else {
+			e.w.WriteString("false")
+		}
+	case *JSON:
+		return e.node(val, depth)
+	default:
+		n, ok := formatNumber(val)
+		if !ok || !validNumber(n) {
+			return fmt.Errorf("Cannot write %T %v as json", v, v)
+		}
+		e.w.WriteString(n)
+	}
+
+	return nil
+
+}
+
+func (e *encoder) node(element *JSON, depth int) error {
+
+	if element == nil {
+		e.w.WriteString("null")
+		return nil
+	}
+
+	switch element.ValueType {
+	case Null:
+		e.w.WriteString("null")
+	case String:
+		e.string(element.StringVal)
+	case Number:
+		if !validNumber(element.StringVal) {
+			return fmt.Errorf("Cannot write number %q as json", element.StringVal)
+		}
+		e.w.WriteString(element.StringVal)
+	case Boolean:
+		return e.value(element.BoolVal, depth)
+	case Array:
+		e.w.WriteByte('[')
+		for i, v := range element.ArrayVals {
+			if i > 0 {
+				e.w.WriteByte(',')
+			}
+			e.newline(depth + 1)
+			if err := e.value(v, depth+1); err != nil {
+				return err
+			}
+		}
+		if len(element.ArrayVals) > 0 {
+			e.newline(depth)
+		}
+		e.w.WriteByte(']')
+	case Object:
+		keys := element.Keys()
+		e.w.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				e.w.WriteByte(',')
+			}
+			e.newline(depth + 1)
+			e.string(key)
+			e.w.WriteByte(':')
+			if e.indent != "" || e.prefix != "" {
+				e.w.WriteByte(' ')
+			}
+			if err := e.value(element.ObjectVals[key], depth+1); err != nil {
+				return err
+			}
+		}
+		if len(keys) > 0 {
+			e.newline(depth)
+		}
+		e.w.WriteByte('}')
+	default:
+		if element.Err != nil {
+			return element.Err
+		}
+		return fmt.Errorf("Cannot write an invalid value as json")
+	}
+
+	return nil
+
+}
+
+func (e *encoder) newline(depth int) {
+
+	if e.indent == "" && e.prefix == "" {
+		return
+	}
+	e.w.WriteByte('\n')
+	e.w.WriteString(e.prefix)
+	for i := 0; i < depth; i++ {
+		e.w.WriteString(e.indent)
+	}
+
+}
+
+const hex = "0123456789abcdef"
+
+// string writes s quoted, escaping what json requires and replacing invalid UTF-8
+func (e *encoder) string(s string) {
+
+	e.w.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			e.w.WriteString(s[start:i])
+			switch c {
+			case '"', '\\':
+				e.w.WriteByte('\\')
+				e.w.WriteByte(c)
+			case '\n':
+				e.w.WriteString(`\n`)
+			case '\r':
+				e.w.WriteString(`\r`)
+			case '\t':
+				e.w.WriteString(`\t`)
+			default:
+				e.w.WriteString(`\u00`)
+				e.w.WriteByte(hex[c>>4])
+				e.w.WriteByte(hex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			e.w.WriteString(s[start:i])
+			e.w.WriteString(`\ufffd`)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' { // valid json but not valid javascript
+			e.w.WriteString(s[start:i])
+			e.w.WriteString(`\u202`)
+			e.w.WriteByte(hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	e.w.WriteString(s[start:])
+	e.w.WriteByte('"')
+
+}
@@ -0,0 +1,81 @@
+package jsparser
+
+import (
+	"fmt"
+	"io"
+)
+
+// SyntaxError describes where and why the input is not valid json
+type SyntaxError struct {
+	Offset   uint64 // byte offset of the offending byte
+	Line     int    // line of the offending byte, starting from 1
+	Column   int    // column of the offending byte, starting from 1
+	Byte     byte   // offending byte, zero when the input ended
+	EOF      bool   // input ended before the json was complete
+	Expected string // what was expected instead
+	Context  string // excerpt of the input around the offending byte
+}
+
+func (e *SyntaxError) Error() string {
+
+	if e.EOF {
+		return fmt.Sprintf("Invalid json: unexpected end of input at line %d, column %d (offset %d), expected %s", e.Line, e.Column, e.Offset, e.Expected)
+	}
+	return fmt.Sprintf("Invalid json: unexpected %q at line %d, column %d (offset %d), expected %s near %q", e.Byte, e.Line, e.Column, e.Offset, e.Expected, e.Context)
+
+}
+
+// DuplicateKeyError reports a property repeated in an object
+type DuplicateKeyError struct {
+	Key    string
+	Offset uint64 // byte offset of the repeated property name
+	Line   int
+	Column int
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("Duplicate key %q at line %d, column %d (offset %d)", e.Key, e.Line, e.Column, e.Offset)
+}
+
+// syntaxError reports the last byte read as unexpected. Read errors other
+// than io.EOF are returned as they are.
+func (j *JsonParser) syntaxError(expected string) error {
+
+	if j.readErr != nil && j.readErr != io.EOF {
+		return j.readErr
+	}
+
+	e := &SyntaxError{Expected: expected}
+
+	if j.readErr == io.EOF || j.TotalReadSize == 0 {
+		e.EOF = true
+		e.Offset = j.TotalReadSize
+		e.Line = j.line + 1
+		e.Column = j.column + 1
+		return e
+	}
+
+	e.Offset = j.TotalReadSize - 1
+	e.Byte = j.recent[e.Offset%uint64(len(j.recent))]
+	e.Line = j.line + 1
+	e.Column = j.column
+	if e.Byte == '\n' {
+		e.Line = j.line
+		e.Column = j.prevColumn + 1
+	}
+
+	// bytes read before the offending one are still in recent, the following are peeked
+	size := uint64(len(j.recent))
+	if j.TotalReadSize < size {
+		size = j.TotalReadSize
+	}
+	context := make([]byte, 0, 2*len(j.recent))
+	for i := j.TotalReadSize - size; i < j.TotalReadSize; i++ {
+		context = append(context, j.recent[i%uint64(len(j.recent))])
+	}
+	next, _ := j.reader.Peek(len(j.recent))
+	e.Context = string(append(context, next...))
+
+	return e
+
+}
@@ -0,0 +1,1150 @@
+package jsparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// JSONPath is a compiled RFC 9535 query such as $..author,
+// $.books[?@.price < 10].title or $['key with.dot'][1:3]. It can be reused
+// across elements and goroutines.
+type JSONPath struct {
+	expr  string
+	query *jpQuery
+}
+
+// CompileJSONPath parses a JSONPath query. A query not starting with $ is
+// taken as relative to the root, so books[*].title is $.books[*].title.
+func CompileJSONPath(expr string) (*JSONPath, error) {
+
+	src := expr
+	if !strings.HasPrefix(src, "$") {
+		if strings.HasPrefix(src, "[") {
+			src = "$" + src
+		} else {
+			src = "$." + src
+		}
+	}
+
+	p := &jpParser{expr: src, pos: 1}
+	q, err := p.segments(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.expr) {
+		return nil, p.errorf("unexpected %q", p.expr[p.pos])
+	}
+	return &JSONPath{expr: expr, query: q}, nil
+
+}
+
+// compileFilter parses a filter expression, with or without the leading ?
+func compileFilter(expr string) (jpLogical, error) {
+
+	p := &jpParser{expr: expr}
+	p.skipSpace()
+	p.consume("?")
+
+	filter, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.expr) {
+		return nil, p.errorf("unexpected %q", p.expr[p.pos])
+	}
+	return filter, nil
+
+}
+
+// MustCompileJSONPath is like CompileJSONPath but panics on invalid queries
+func MustCompileJSONPath(expr string) *JSONPath {
+
+	p, err := CompileJSONPath(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+
+}
+
+// String returns the query as given to CompileJSONPath
+func (p *JSONPath) String() string {
+	return p.expr
+}
+
+// Select returns the nodes matched by the query in document order
+func (p *JSONPath) Select(element *JSON) []*JSON {
+
+	nodes := []*JSON{}
+	if element == nil {
+		return nodes
+	}
+	for _, v := range p.query.nodes(element, element) {
+		nodes = append(nodes, toNode(v))
+	}
+	return nodes
+
+}
+
+// Query compiles expr and returns the nodes it matches, see CompileJSONPath
+func (element *JSON) Query(expr string) ([]*JSON, error) {
+
+	p, err := CompileJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	return p.Select(element), nil
+
+}
+
+// kinds of selector inside a segment
+const (
+	jpName = iota
+	jpWildcard
+	jpIndex
+	jpSlice
+	jpFilter
+)
+
+type jpSelector struct {
+	kind   int
+	name   string
+	index  int // index, or start of a slice
+	end    int
+	step   int
+	hasIdx bool // start of the slice is given
+	hasEnd bool
+	filter jpLogical
+}
+
+type jpSegment struct {
+	descendant bool
+	selectors  []jpSelector
+}
+
+// jpQuery is a query from the root ($) or, inside filters, from the current node (@)
+type jpQuery struct {
+	relative bool
+	segments []jpSegment
+	singular bool // only names and indexes, selects at most one node
+}
+
+// nodes evaluates the query. Values are kept as found in ObjectVals and ArrayVals.
+func (q *jpQuery) nodes(root, cur interface{}) []interface{} {
+
+	nodes := []interface{}{root}
+	if q.relative {
+		nodes[0] = cur
+	}
+
+	for _, seg := range q.segments {
+		var out []interface{}
+		for _, n := range nodes {
+			if seg.descendant {
+				out = seg.descend(root, n, out)
+			} else {
+				out = seg.apply(root, n, out)
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		nodes = out
+	}
+
+	return nodes
+
+}
+
+func (seg *jpSegment) apply(root, n interface{}, out []interface{}) []interface{} {
+
+	for i := range seg.selectors {
+		out = seg.selectors[i].apply(root, n, out)
+	}
+	return out
+
+}
+
+// descend applies the selectors to n and all of its descendants
+func (seg *jpSegment) descend(root, n interface{}, out []interface{}) []interface{} {
+
+	out = seg.apply(root, n, out)
+
+	node, ok := n.(*JSON)
+	if !ok || node == nil {
+		return out
+	}
+	switch node.ValueType {
+	case Object:
+		for _, key := range node.Keys() {
+			out = seg.descend(root, node.ObjectVals[key], out)
+		}
+	case Array:
+		for _, v := range node.ArrayVals {
+			out = seg.descend(root, v, out)
+		}
+	}
+	return out
+
+}
+
+func (s *jpSelector) apply(root, n interface{}, out []interface{}) []interface{} {
+
+	node, ok := n.(*JSON)
+	if !ok || node == nil || (node.ValueType != Object && node.ValueType != Array) {
+		return out
+	}
+	isArray := node.ValueType == Array
+
+	switch s.kind {
+	case jpName:
+
+		if v, ok := node.ObjectVals[s.name]; ok && !isArray {
+			out = append(out, v)
+		}
+
+	case jpWildcard, jpFilter:
+
+		if isArray {
+			for _, v := range node.ArrayVals {
+				if s.kind == jpWildcard || s.filter.test(root, v) {
+					out = append(out, v)
+				}
+			}
+		} else {
+			for _, key := range node.Keys() {
+				v := node.ObjectVals[key]
+				if s.kind == jpWildcard || s.filter.test(root, v) {
+					out = append(out, v)
+				}
+			}
+		}
+
+	case jpIndex:
+
+		i := s.index
+		if i < 0 {
+			i += len(node.ArrayVals)
+		}
+		if isArray && i >= 0 && i < len(node.ArrayVals) {
+			out = append(out, node.ArrayVals[i])
+		}
+
+	case jpSlice:
+
+		if !isArray {
+			break
+		}
+		for _, i := range s.slice(len(node.ArrayVals)) {
+			out = append(out, node.ArrayVals[i])
+		}
+
+	}
+
+	return out
+
+}
+
+// slice returns the selected indexes as defined in RFC 9535 section 2.3.4.2
+func (s *jpSelector) slice(n int) []int {
+
+	if s.step == 0 {
+		return nil
+	}
+
+	norm := func(i int) int {
+		if i < 0 {
+			return n + i
+		}
+		return i
+	}
+	clamp := func(i, lo, hi int) int {
+		return min(max(i, lo), hi)
+	}
+
+	var idx []int
+	if s.step > 0 {
+		start, end := 0, n
+		if s.hasIdx {
+			start = norm(s.index)
+		}
+		if s.hasEnd {
+			end = norm(s.end)
+		}
+		for i := clamp(start, 0, n); i < clamp(end, 0, n); i += s.step {
+			idx = append(idx, i)
+		}
+	} else {
+		start, end := n-1, -n-1
+		if s.hasIdx {
+			start = norm(s.index)
+		}
+		if s.hasEnd {
+			end = norm(s.end)
+		}
+		for i := clamp(start, -1, n-1); i > clamp(end, -1, n-1); i += s.step {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+
+}
+
+// jpLogical is a filter expression
+type jpLogical interface {
+	test(root, cur interface{}) bool
+}
+
+type jpOr struct{ left, right jpLogical }
+
+func (e *jpOr) test(root, cur interface{}) bool {
+	return e.left.test(root, cur) || e.right.test(root, cur)
+}
+
+type jpAnd struct{ left, right jpLogical }
+
+func (e *jpAnd) test(root, cur interface{}) bool {
+	return e.left.test(root, cur) && e.right.test(root, cur)
+}
+
+type jpNot struct{ expr jpLogical }
+
+func (e *jpNot) test(root, cur interface{}) bool {
+	return !e.expr.test(root, cur)
+}
+
+// jpExists tests that a query selects at least one node
+type jpExists struct{ query *jpQuery }
+
+func (e *jpExists) test(root, cur interface{}) bool {
+	return len(e.query.nodes(root, cur)) > 0
+}
+
+// jpFuncTest tests the result of match or search
+type jpFuncTest struct{ fn *jpFunction }
+
+func (e *jpFuncTest) test(root, cur interface{}) bool {
+	v, ok := e.fn.call(root, cur)
+	b, _ := v.(bool)
+	return ok && b
+}
+
+type jpCompare struct {
+	op          string
+	left, right interface{} // *jpLiteral, *jpQuery or *jpFunction
+}
+
+func (e *jpCompare) test(root, cur interface{}) bool {
+
+	a, aok := jpEval(e.left, root, cur)
+	b, bok := jpEval(e.right, root, cur)
+
+	switch e.op {
+	case "==":
+		return jpEqual(a, aok, b, bok)
+	case "!=":
+		return !jpEqual(a, aok, b, bok)
+	case "<":
+		return aok && bok && jpLess(a, b)
+	case "<=":
+		return aok && bok && jpLess(a, b) || jpEqual(a, aok, b, bok)
+	case ">":
+		return aok && bok && jpLess(b, a)
+	case ">=":
+		return aok && bok && jpLess(b, a) || jpEqual(a, aok, b, bok)
+	}
+	return false
+
+}
+
+type jpLiteral struct{ value interface{} }
+
+type jpFunction struct {
+	name   string
+	args   []interface{} // *jpLiteral, *jpQuery or *jpFunction
+	re     *regexp.Regexp
+	static bool // the pattern is a literal compiled with the query, re is nil when invalid
+}
+
+// jpEval returns the value of an operand, false when it is Nothing
+func jpEval(o interface{}, root, cur interface{}) (interface{}, bool) {
+
+	switch o := o.(type) {
+	case *jpLiteral:
+		return o.value, true
+	case *jpQuery:
+		nodes := o.nodes(root, cur)
+		if len(nodes) != 1 {
+			return nil, false
+		}
+		return jpValue(nodes[0]), true
+	case *jpFunction:
+		return o.call(root, cur)
+	}
+	return nil, false
+
+}
+
+func (f *jpFunction) call(root, cur interface{}) (interface{}, bool) {
+
+	switch f.name {
+	case "length":
+
+		v, ok := jpEval(f.args[0], root, cur)
+		if !ok {
+			return nil, false
+		}
+		switch v := v.(type) {
+		case string:
+			return json.Number(strconv.Itoa(utf8.RuneCountInString(v))), true
+		case *JSON:
+			return json.Number(strconv.Itoa(v.Len())), true
+		}
+		return nil, false
+
+	case "count":
+
+		n := len(f.args[0].(*jpQuery).nodes(root, cur))
+		return json.Number(strconv.Itoa(n)), true
+
+	case "value":
+
+		nodes := f.args[0].(*jpQuery).nodes(root, cur)
+		if len(nodes) != 1 {
+			return nil, false
+		}
+		return jpValue(nodes[0]), true
+
+	case "match", "search":
+
+		v, ok := jpEval(f.args[0], root, cur)
+		s, isStr := v.(string)
+		if !ok || !isStr {
+			return false, true
+		}
+		re := f.re
+		if !f.static {
+			p, _ := jpEval(f.args[1], root, cur)
+			pattern, isStr := p.(string)
+			if !isStr {
+				return false, true
+			}
+			re = jpRegexp(pattern, f.name == "match")
+		}
+		return re != nil && re.MatchString(s), true
+
+	}
+	return nil, false
+
+}
+
+var jpRegexpCache sync.Map // pattern -> *regexp.Regexp, nil when invalid
+
+// jpRegexp converts an I-Regexp (RFC 9485) to a Go regexp, anchored for match
+func jpRegexp(pattern string, anchored bool) *regexp.Regexp {
+
+	key := pattern
+	if anchored {
+		key = "^" + pattern
+	}
+	if re, ok := jpRegexpCache.Load(key); ok {
+		return re.(*regexp.Regexp)
+	}
+
+	var sb strings.Builder
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			sb.WriteByte(c)
+			i++
+			c = pattern[i]
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '.' && !inClass: // any character but line breaks
+			sb.WriteString(`[^\n\r]`)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+
+	expr := sb.String()
+	if anchored {
+		expr = `^(?:` + expr + `)$`
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		re = nil
+	}
+	jpRegexpCache.Store(key, re)
+	return re
+
+}
+
+// jpValue converts a node to the plain value used in comparisons, objects
+// and arrays stay *JSON and null is nil
+func jpValue(v interface{}) interface{} {
+
+	n, ok := v.(*JSON)
+	if !ok {
+		return v
+	}
+	if n == nil {
+		return nil
+	}
+	switch n.ValueType {
+	case String:
+		return n.StringVal
+	case Number:
+		return json.Number(n.StringVal)
+	case Boolean:
+		return n.BoolVal
+	case Object, Array:
+		return n
+	}
+	return nil
+
+}
+
+func jpEqual(a interface{}, aok bool, b interface{}, bok bool) bool {
+
+	if !aok || !bok {
+		return aok == bok
+	}
+	a, b = jpValue(a), jpValue(b)
+
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		y, ok := b.(bool)
+		return ok && x == y
+	case string:
+		y, ok := b.(string)
+		return ok && x == y
+	case *JSON:
+		y, ok := b.(*JSON)
+		if !ok || x.ValueType != y.ValueType || x.Len() != y.Len() {
+			return false
+		}
+		if x.ValueType == Array {
+			for i := range x.ArrayVals {
+				if !jpEqual(x.ArrayVals[i], true, y.ArrayVals[i], true) {
+					return false
+				}
+			}
+			return true
+		}
+		for key, v := range x.ObjectVals {
+			w, ok := y.ObjectVals[key]
+			if !ok || !jpEqual(v, true, w, true) {
+				return false
+			}
+		}
+		return true
+	}
+
+	xs, xok := formatNumber(a)
+	ys, yok := formatNumber(b)
+	return xok && yok && compareNumbers(xs, ys) == 0
+
+}
+
+// jpLess compares numbers and strings, other values are not ordered
+func jpLess(a, b interface{}) bool {
+
+	a, b = jpValue(a), jpValue(b)
+
+	if x, ok := a.(string); ok {
+		y, ok := b.(string)
+		return ok && x < y
+	}
+
+	xs, xok := formatNumber(a)
+	ys, yok := formatNumber(b)
+	return xok && yok && compareNumbers(xs, ys) < 0
+
+}
+
+// compareNumbers compares json numbers, exactly when both are 64 bit integers
+func compareNumbers(a, b string) int {
+
+	if x, err := strconv.ParseInt(a, 10, 64); err == nil {
+		if y, err := strconv.ParseInt(b, 10, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	x, _ := strconv.ParseFloat(a, 64)
+	y, _ := strconv.ParseFloat(b, 64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+
+}
+
+// jpParser is a recursive descent parser of the RFC 9535 grammar
+type jpParser struct {
+	expr string
+	pos  int
+}
+
+func (p *jpParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid json path %q at offset %d: %s", p.expr, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *jpParser) peek() byte {
+	if p.pos < len(p.expr) {
+		return p.expr[p.pos]
+	}
+	return 0
+}
+
+func (p *jpParser) skipSpace() {
+	for p.pos < len(p.expr) && isSpace(p.expr[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *jpParser) consume(s string) bool {
+	if strings.HasPrefix(p.expr[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// segments parses the segments following $ or @
+func (p *jpParser) segments(relative bool) (*jpQuery, error) {
+
+	q := &jpQuery{relative: relative, singular: true}
+
+	for {
+
+		start := p.pos
+		p.skipSpace()
+
+		var seg jpSegment
+		var err error
+
+		switch {
+		case p.consume(".."):
+			seg.descendant = true
+			if p.peek() == '[' {
+				p.pos++
+				seg.selectors, err = p.bracketed()
+			} else {
+				seg.selectors, err = p.shorthand()
+			}
+		case p.consume("."):
+			seg.selectors, err = p.shorthand()
+		case p.consume("["):
+			seg.selectors, err = p.bracketed()
+		default:
+			p.pos = start
+			return q, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if seg.descendant || len(seg.selectors) != 1 || (seg.selectors[0].kind != jpName && seg.selectors[0].kind != jpIndex) {
+			q.singular = false
+		}
+		q.segments = append(q.segments, seg)
+	}
+
+}
+
+// shorthand parses * or a member name after . or ..
+func (p *jpParser) shorthand() ([]jpSelector, error) {
+
+	if p.consume("*") {
+		return []jpSelector{{kind: jpWildcard}}, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.expr) {
+		c := p.expr[p.pos]
+		if c == '_' || c >= 0x80 || (c|0x20 >= 'a' && c|0x20 <= 'z') || (p.pos > start && c >= '0' && c <= '9') {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if p.pos == start {
+		return nil, p.errorf("expected member name or '*'")
+	}
+	return []jpSelector{{kind: jpName, name: p.expr[start:p.pos]}}, nil
+
+}
+
+// bracketed parses the selectors after [ up to the closing ]
+func (p *jpParser) bracketed() ([]jpSelector, error) {
+
+	var sels []jpSelector
+
+	for {
+		p.skipSpace()
+		sel, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+
+		p.skipSpace()
+		if p.consume("]") {
+			return sels, nil
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expected ',' or ']'")
+		}
+	}
+
+}
+
+func (p *jpParser) selector() (jpSelector, error) {
+
+	switch c := p.peek(); {
+	case c == '\'' || c == '"':
+
+		s, err := p.string()
+		return jpSelector{kind: jpName, name: s}, err
+
+	case c == '*':
+
+		p.pos++
+		return jpSelector{kind: jpWildcard}, nil
+
+	case c == '?':
+
+		p.pos++
+		expr, err := p.or()
+		return jpSelector{kind: jpFilter, filter: expr}, err
+
+	}
+
+	sel := jpSelector{kind: jpIndex, step: 1}
+
+	var err error
+	if c := p.peek(); c == '-' || (c >= '0' && c <= '9') {
+		if sel.index, err = p.int(); err != nil {
+			return sel, err
+		}
+		sel.hasIdx = true
+	}
+
+	p.skipSpace()
+	if !p.consume(":") {
+		if !sel.hasIdx {
+			return sel, p.errorf("expected selector")
+		}
+		return sel, nil
+	}
+
+	sel.kind = jpSlice
+	p.skipSpace()
+	if c := p.peek(); c == '-' || (c >= '0' && c <= '9') {
+		if sel.end, err = p.int(); err != nil {
+			return sel, err
+		}
+		sel.hasEnd = true
+	}
+
+	p.skipSpace()
+	if p.consume(":") {
+		p.skipSpace()
+		if c := p.peek(); c == '-' || (c >= '0' && c <= '9') {
+			if sel.step, err = p.int(); err != nil {
+				return sel, err
+			}
+		}
+	}
+
+	return sel, nil
+
+}
+
+// int parses an index, without leading zeros and within the I-JSON range
+func (p *jpParser) int() (int, error) {
+
+	start := p.pos
+	p.consume("-")
+	digits := p.pos
+	for p.pos < len(p.expr) && p.expr[p.pos] >= '0' && p.expr[p.pos] <= '9' {
+		p.pos++
+	}
+
+	s := p.expr[start:p.pos]
+	if p.pos == digits || (p.expr[digits] == '0' && (p.pos-digits > 1 || digits > start)) {
+		p.pos = start
+		return 0, p.errorf("invalid integer %q", s)
+	}
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || i > 1<<53-1 || i < -(1<<53-1) {
+		p.pos = start
+		return 0, p.errorf("integer %q out of range", s)
+	}
+	return int(i), nil
+
+}
+
+// string parses a single or double quoted string literal
+func (p *jpParser) string() (string, error) {
+
+	quote := p.expr[p.pos]
+	p.pos++
+
+	var sb strings.Builder
+	for p.pos < len(p.expr) {
+
+		c := p.expr[p.pos]
+		p.pos++
+
+		switch {
+		case c == quote:
+			return sb.String(), nil
+		case c < 0x20:
+			return "", p.errorf("control character in string")
+		case c != '\\':
+			sb.WriteByte(c)
+			continue
+		}
+
+		if p.pos >= len(p.expr) {
+			break
+		}
+		e := p.expr[p.pos]
+		p.pos++
+
+		switch e {
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case '/', '\\':
+			sb.WriteByte(e)
+		case '\'', '"':
+			if e != quote {
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+			sb.WriteByte(e)
+		case 'u':
+			r, ok := p.u4()
+			if !ok {
+				return "", p.errorf("invalid unicode escape")
+			}
+			if utf16.IsSurrogate(r) {
+				var r2 rune
+				if p.consume(`\u`) {
+					r2, ok = p.u4()
+				}
+				if r = utf16.DecodeRune(r, r2); !ok || r == utf8.RuneError {
+					return "", p.errorf("invalid surrogate pair")
+				}
+			}
+			sb.WriteRune(r)
+		default:
+			return "", p.errorf("invalid escape \\%c", e)
+		}
+	}
+
+	return "", p.errorf("unterminated string")
+
+}
+
+func (p *jpParser) u4() (rune, bool) {
+
+	if p.pos+4 > len(p.expr) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(p.expr[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	p.pos += 4
+	return rune(n), true
+
+}
+
+func (p *jpParser) or() (jpLogical, error) {
+
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipSpace()
+		if !p.consume("||") {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &jpOr{left, right}
+	}
+
+}
+
+func (p *jpParser) and() (jpLogical, error) {
+
+	left, err := p.basic()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipSpace()
+		if !p.consume("&&") {
+			return left, nil
+		}
+		right, err := p.basic()
+		if err != nil {
+			return nil, err
+		}
+		left = &jpAnd{left, right}
+	}
+
+}
+
+// basic parses a parenthesized expression, a test or a comparison
+func (p *jpParser) basic() (jpLogical, error) {
+
+	p.skipSpace()
+
+	if p.consume("!") {
+		p.skipSpace()
+		if p.peek() != '(' && p.peek() != '@' && p.peek() != '$' && !isNameStart(p.peek()) {
+			return nil, p.errorf("expected '(', query or function after '!'")
+		}
+		paren := p.peek() == '('
+		expr, err := p.basic()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := expr.(*jpCompare); ok && !paren {
+			return nil, p.errorf("comparison must be in parentheses after '!'")
+		}
+		return &jpNot{expr}, nil
+	}
+
+	if p.consume("(") {
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume(")") {
+			return nil, p.errorf("expected ')'")
+		}
+		return expr, nil
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	op := ""
+	for _, o := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(o) {
+			op = o
+			break
+		}
+	}
+
+	if op == "" { // test expression
+		switch o := left.(type) {
+		case *jpQuery:
+			return &jpExists{o}, nil
+		case *jpFunction:
+			if o.name == "match" || o.name == "search" {
+				return &jpFuncTest{o}, nil
+			}
+			return nil, p.errorf("function %s() must be compared", o.name)
+		}
+		return nil, p.errorf("literal must be compared")
+	}
+
+	p.skipSpace()
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.comparable(left); err != nil {
+		return nil, err
+	}
+	if err := p.comparable(right); err != nil {
+		return nil, err
+	}
+	return &jpCompare{op: op, left: left, right: right}, nil
+
+}
+
+// comparable checks that an operand has a single value
+func (p *jpParser) comparable(o interface{}) error {
+
+	switch o := o.(type) {
+	case *jpQuery:
+		if !o.singular {
+			return p.errorf("query compared must select a single node")
+		}
+	case *jpFunction:
+		if o.name == "match" || o.name == "search" {
+			return p.errorf("function %s() can't be compared", o.name)
+		}
+	}
+	return nil
+
+}
+
+// operand parses a query, a literal or a function call
+func (p *jpParser) operand() (interface{}, error) {
+
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+
+		p.pos++
+		return p.segments(c == '@')
+
+	case c == '\'' || c == '"':
+
+		s, err := p.string()
+		return &jpLiteral{s}, err
+
+	case c == '-' || (c >= '0' && c <= '9'):
+
+		start := p.pos
+		state := numBegin
+		for p.pos < len(p.expr) {
+			next := numberStep(state, p.expr[p.pos])
+			if next == numInvalid {
+				break
+			}
+			state = next
+			p.pos++
+		}
+		if !numberComplete(state) {
+			return nil, p.errorf("invalid number %q", p.expr[start:p.pos])
+		}
+		return &jpLiteral{json.Number(p.expr[start:p.pos])}, nil
+
+	case isNameStart(c):
+
+		start := p.pos
+		for p.pos < len(p.expr) && (isNameStart(p.expr[p.pos]) || p.expr[p.pos] == '_' || (p.expr[p.pos] >= '0' && p.expr[p.pos] <= '9')) {
+			p.pos++
+		}
+		name := p.expr[start:p.pos]
+
+		if p.peek() == '(' {
+			p.pos++
+			return p.function(name)
+		}
+
+		switch name {
+		case "true":
+			return &jpLiteral{true}, nil
+		case "false":
+			return &jpLiteral{false}, nil
+		case "null":
+			return &jpLiteral{nil}, nil
+		}
+		p.pos = start
+		return nil, p.errorf("unknown name %q", name)
+
+	}
+
+	return nil, p.errorf("expected query, literal or function")
+
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+// function parses the arguments of length, count, value, match and search
+func (p *jpParser) function(name string) (*jpFunction, error) {
+
+	f := &jpFunction{name: name}
+
+	p.skipSpace()
+	for !p.consume(")") {
+		if len(f.args) > 0 {
+			if !p.consume(",") {
+				return nil, p.errorf("expected ',' or ')'")
+			}
+			p.skipSpace()
+		}
+		arg, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		f.args = append(f.args, arg)
+		p.skipSpace()
+	}
+
+	nargs := 1
+	nodes := false // the argument is a node list instead of a value
+	switch name {
+	case "length":
+	case "count", "value":
+		nodes = true
+	case "match", "search":
+		nargs = 2
+	default:
+		return nil, p.errorf("unknown function %s()", name)
+	}
+
+	if len(f.args) != nargs {
+		return nil, p.errorf("function %s() takes %d arguments", name, nargs)
+	}
+	for _, arg := range f.args {
+		if _, ok := arg.(*jpQuery); nodes && !ok {
+			return nil, p.errorf("function %s() takes a query", name)
+		}
+		if err := p.comparable(arg); !nodes && err != nil {
+			return nil, p.errorf("function %s() takes single values", name)
+		}
+	}
+
+	if lit, ok := f.args[len(f.args)-1].(*jpLiteral); ok && nargs == 2 {
+		pattern, _ := lit.value.(string)
+		f.re = jpRegexp(pattern, name == "match")
+		f.static = true
+	}
+
+	return f, nil
+
+}
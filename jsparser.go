@@ -3,22 +3,62 @@ package jsparser
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"iter"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode/utf16"
 )
 
 type JsonParser struct {
-	reader        *bufio.Reader
-	loopProp      []byte
-	resChan       chan *JSON
-	isResArr      bool
-	skipProps     map[string]bool
-	TotalReadSize uint64
-	lastReadSize  int
-	scratch       *scratch
+	reader         *bufio.Reader
+	loops          []*selector
+	stack          []pathFrame
+	state          int
+	resChan        chan *JSON
+	isResArr       bool
+	skipProps      map[string]bool
+	TotalReadSize  uint64
+	lastReadSize   int
+	scratch        *scratch
+	err            error
+	ndjson         bool
+	treeDepth      int
+	readErr        error
+	recent         [16]byte // last bytes read, for error context
+	line           int
+	column         int
+	prevColumn     int
+	ctx            context.Context
+	done           bool
+	current        *JSON
+	numberMode     NumberMode
+	lenientNumbers bool
+	preserveOrder  bool
+	locations      bool
+	duplicateKeys  DuplicatePolicy
+	propOffset     uint64 // position of the property name being read
+	propLine       int
+	propColumn     int
+	tee            *tee      // copies the input while rewriting
+	where          jpLogical // filter of the loop values
+	skipRules      []*skipRule
+	treePath       []pathFrame // path inside the loop value, kept when there are skip rules
+	pathBuf        []pathFrame
+	keepRoot       *keepNode // KeepProps paths
+	keepAll        bool      // the Where filter needs whole values
+	keep           *keepNode // KeepProps level of the object being read, nil keeps all
+	keepNext       *keepNode // KeepProps level of the property being read
+	ctxCheck       uint64    // TotalReadSize at which the context is checked next
+	cancelled      bool      // parsing stopped because the context is done
+	data           []byte    // whole input of NewBytesParser
+	zeroCopy       bool      // the string read is data[strStart:strEnd], not in scratch
+	strStart       uint64
+	strEnd         uint64
 }
 
 // JSON parsed result
@@ -29,6 +69,10 @@ type JSON struct {
 	ObjectVals map[string]interface{}
 	ValueType  ValueType
 	Err        error
+	Selector   string // loop property or path which produced the result
+	Location   string // JSON Pointer of the result in the document when the parser sets Locations
+	keys       []string
+	collected  bool // array of the values of a duplicated key
 }
 
 // ValueType of JSON value
@@ -49,7 +93,7 @@ func NewJSONParser(reader *bufio.Reader, loopProp string) *JsonParser {
 
 	j := &JsonParser{
 		reader:    reader,
-		loopProp:  []byte(loopProp),
+		loops:     []*selector{newSelector(loopProp)},
 		resChan:   make(chan *JSON, 256),
 		skipProps: map[string]bool{},
 		scratch:   &scratch{data: make([]byte, 2048), dataRes: make([]*JSON, 2048)},
@@ -57,11 +101,152 @@ func NewJSONParser(reader *bufio.Reader, loopProp string) *JsonParser {
 	return j
 }
 
+// NewReaderParser reads from any io.Reader through a buffer of bufSize bytes,
+// 64KB when bufSize is zero or less
+func NewReaderParser(reader io.Reader, loopProp string, bufSize int) *JsonParser {
+
+	if bufSize <= 0 {
+		bufSize = 65536
+	}
+	return NewJSONParser(bufio.NewReaderSize(reader, bufSize), loopProp)
+
+}
+
+// NewBytesParser parses json held in memory. String values without escapes
+// reference data instead of being copied, so data must not be modified while
+// the results are in use.
+func NewBytesParser(data []byte, loopProp string) *JsonParser {
+
+	j := NewReaderParser(bytes.NewReader(data), loopProp, min(max(len(data), 16), 65536))
+	j.data = data
+	return j
+
+}
+
+// NewJSONPathParser loops over the value at an anchored path such as
+// $.catalog.books or data.results instead of every property with a given name.
+func NewJSONPathParser(reader *bufio.Reader, loopPath string) *JsonParser {
+
+	j := NewJSONParser(reader, "")
+	j.loops = nil
+	return j.LoopPaths([]string{loopPath})
+}
+
+// NDJSON reads the input as a sequence of whitespace or newline separated
+// values. Matched root values are returned whole, root arrays included.
+func (j *JsonParser) NDJSON() *JsonParser {
+
+	j.ndjson = true
+	return j
+
+}
+
+// Locations sets the Location of every result, the JSON Pointer of the value
+func (j *JsonParser) Locations() *JsonParser {
+
+	j.locations = true
+	return j
+
+}
+
+// PreserveOrder keeps the order of object properties as in the input, see JSON.Keys
+func (j *JsonParser) PreserveOrder() *JsonParser {
+
+	j.preserveOrder = true
+	return j
+
+}
+
+// DuplicatePolicy decides what happens to properties repeated in an object
+type DuplicatePolicy int8
+
+const (
+	DuplicateLastWins  DuplicatePolicy = iota // the last value is kept
+	DuplicateFirstWins                        // the first value is kept
+	DuplicateError                            // a *DuplicateKeyError ends parsing
+	DuplicateCollect                          // all values are kept in an array
+)
+
+// DuplicateKeys sets the policy for repeated properties, DuplicateLastWins by default
+func (j *JsonParser) DuplicateKeys(policy DuplicatePolicy) *JsonParser {
+
+	j.duplicateKeys = policy
+	return j
+
+}
+
+// LoopProps adds more property names to loop over in the same pass
+func (j *JsonParser) LoopProps(loopProps []string) *JsonParser {
+
+	for _, p := range loopProps {
+		j.loops = append(j.loops, newSelector(p))
+	}
+	return j
+
+}
+
+// LoopPaths adds more anchored paths to loop over in the same pass
+func (j *JsonParser) LoopPaths(loopPaths []string) *JsonParser {
+
+	for _, p := range loopPaths {
+		sel, err := parseLoopPath(p)
+		if err != nil {
+			if j.err == nil {
+				j.err = err
+			}
+			continue
+		}
+		j.loops = append(j.loops, sel)
+	}
+	return j
+
+}
+
+// Where sends only the loop values matching a JSONPath filter expression
+// such as @.status == "active" && @.price > 10, see CompileJSONPath. Both @
+// and $ refer to the loop value. Invalid expressions are reported as the
+// first result.
+func (j *JsonParser) Where(expr string) *JsonParser {
+
+	filter, err := compileFilter(expr)
+	if err != nil {
+		if j.err == nil {
+			j.err = err
+		}
+		return j
+	}
+	j.where = filter
+	j.keepFilterFields()
+	return j
+
+}
+
+// SkipProps leaves properties out of the results. A plain name is skipped at
+// any depth. Paths like comments.id or *.metadata are matched against the end
+// of the nesting path and $.books[*].reviews from the document root, with *
+// and ? globs in names. Names containing dots or globs are quoted like
+// ["user.name"] or escaped like user\.name. Array levels only count in rules
+// having an index.
 func (j *JsonParser) SkipProps(skipProps []string) *JsonParser {
 
 	if len(skipProps) > 0 {
 		for _, s := range skipProps {
-			j.skipProps[s] = true
+			if !strings.ContainsAny(s, ".[*?$\\") {
+				j.skipProps[s] = true
+				continue
+			}
+			rule, err := parseSkipRule(s)
+			if err != nil {
+				if j.err == nil {
+					j.err = err
+				}
+				continue
+			}
+			if seg := rule.segments[0]; len(rule.segments) == 1 && !rule.anchored && seg.literal {
+				j.skipProps[seg.key] = true // a quoted plain name
+				continue
+			}
+			j.skipRules = append(j.skipRules, rule)
 		}
 	}
 	return j
@@ -76,51 +261,133 @@ func (j *JsonParser) Stream() chan *JSON {
 
 }
 
+// StreamContext works like Stream but stops parsing and closes the channel when
+// ctx is done, with ctx.Err() as the final result. Results not yet received
+// when ctx is done may be dropped.
+func (j *JsonParser) StreamContext(ctx context.Context) chan *JSON {
+
+	j.ctx = ctx
+	go j.parse()
+
+	return j.resChan
+
+}
+
 func (j *JsonParser) Parse() []*JSON {
 
 	j.isResArr = true
 	j.parse()
 	return j.scratch.allRes()
 
+}
+
+// Next reads the next result without a background goroutine. It returns false
+// at the end of input or on error, see Err.
+func (j *JsonParser) Next() bool {
+
+	j.current = j.next()
+	return j.current != nil && j.current.Err == nil
+
+}
+
+// Value returns the result read by Next
+func (j *JsonParser) Value() *JSON {
+
+	if j.current == nil || j.current.Err != nil {
+		return nil
+	}
+	return j.current
+
+}
+
+// Err returns the error which stopped Next, nil at the end of valid input
+func (j *JsonParser) Err() error {
+
+	if j.current == nil {
+		return nil
+	}
+	return j.current.Err
+
+}
+
+// All returns an iterator over the results. An error is yielded with a nil
+// result and ends the iteration.
+func (j *JsonParser) All() iter.Seq2[*JSON, error] {
+
+	return func(yield func(*JSON, error) bool) {
+		for j.Next() {
+			if !yield(j.Value(), nil) {
+				return
+			}
+		}
+		if err := j.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+
 }
 func (element *JSON) GetAllNodes(xpath string) map[string]*JSON {
-	var path, paths string
-	xpaths := strings.SplitN(xpath, ".", 2)
-	if len(xpaths) > 1 {
-		paths = xpaths[1]
+	steps, err := parseNodePath(xpath)
+	if err != nil {
+		return map[string]*JSON{}
 	}
-	path = xpaths[0]
-	if elementAux, ok := element.ObjectVals[path]; ok {
+	for _, step := range steps {
+		elementAux, ok := element.ObjectVals[step.key]
+		if !ok || step.index != math.MaxInt64 {
+			return map[string]*JSON{}
+		}
 		if element, ok = elementAux.(*JSON); !ok {
 			return map[string]*JSON{}
 		}
-	} else {
-		return map[string]*JSON{}
-	}
-
-	if len(xpaths) > 1 {
-		return element.GetAllNodes(paths)
 	}
 	return element.GetObjectVals()
 }
+
+// GetNodes returns the values at a path like a.b[0].c. Keys with dots or
+// brackets are quoted, a["user.name"].b, or escaped, a.user\.name.b. Paths
+// starting with / are JSON Pointers. Malformed paths select nothing, see Lookup.
 func (element *JSON) GetNodes(xpath string) []*JSON {
-	var path, paths string
-	xpaths := strings.SplitN(xpath, ".", 2)
-	if len(xpaths) > 1 {
-		paths = xpaths[1]
+	if strings.HasPrefix(xpath, "/") {
+		node, err := element.Pointer(xpath)
+		if err != nil {
+			return []*JSON{}
+		}
+		return []*JSON{node}
+	}
+	steps, err := parseNodePath(xpath)
+	if err != nil {
+		return []*JSON{}
 	}
-	path = xpaths[0]
-	path, index := element.pathIndex(path)
+	return element.getNodes(steps)
+}
+
+// Lookup is like GetNodes but reports malformed paths
+func (element *JSON) Lookup(xpath string) ([]*JSON, error) {
+	if strings.HasPrefix(xpath, "/") {
+		if _, err := parsePointer(xpath); err != nil {
+			return nil, err
+		}
+		return element.GetNodes(xpath), nil
+	}
+	steps, err := parseNodePath(xpath)
+	if err != nil {
+		return nil, err
+	}
+	return element.getNodes(steps), nil
+}
+func (element *JSON) getNodes(steps []nodeStep) []*JSON {
+	path, index := steps[0].key, steps[0].index
+	paths := steps[1:]
 
 	if element == nil {
 		return []*JSON{}
 	}
 	elementAux := element.ObjectVals[path]
 	if e, ok := elementAux.(*JSON); ok {
-		if paths == "" {
+		if len(paths) == 0 {
 			if len(e.ArrayVals) != 0 {
 				return e.GetArrayVals(index)
-			} else if e.IsEmpty() {
+			} else if e.IsEmpty() && !e.IsNull() {
 				return []*JSON{}
 			}
 			return []*JSON{e}
@@ -128,12 +395,12 @@ func (element *JSON) GetNodes(xpath string) []*JSON {
 			for i, eArr := range e.ArrayVals {
 				if i == int(index) {
 					if eAux, ok := eArr.(*JSON); ok {
-						return eAux.GetNodes(paths)
+						return eAux.getNodes(paths)
 					}
 				}
 			}
 		}
-		return e.GetNodes(paths)
+		return e.getNodes(paths)
 	} else if path != "" {
 		if element.IsEmpty() {
 			return []*JSON{}
@@ -142,42 +409,30 @@ func (element *JSON) GetNodes(xpath string) []*JSON {
 			if element, ok = e.(*JSON); ok {
 				elementAux = element.ObjectVals[path]
 				if eAux, ok := elementAux.(*JSON); ok {
-					if paths == "" {
+					if len(paths) == 0 {
 						return []*JSON{eAux}
 					}
-					return element.GetNodes(paths)
+					return element.getNodes(paths)
 				} else if elements, ok := elementAux.([]*JSON); ok {
-					if paths == "" && index == 0 {
+					if len(paths) == 0 && index == 0 {
 						return elements
-					} else if paths == "" {
+					} else if len(paths) == 0 {
 						for i, e := range elements {
 							if i == int(index) {
 								return []*JSON{e}
 							}
 						}
 					}
-				} else if paths == "" {
-					return []*JSON{
-						{
-							StringVal: stringify(elementAux),
-							BoolVal:   false,
-							ValueType: element.ValueType,
-						},
-					}
+				} else if len(paths) == 0 {
+					return []*JSON{toNode(elementAux)}
 				}
 			}
 		}
 	}
-	if elementAux == nil || elementAux == "" {
+	if elementAux == nil {
 		return []*JSON{}
 	}
-	return []*JSON{
-		{
-			StringVal: stringify(elementAux),
-			BoolVal:   false,
-			ValueType: 6,
-		},
-	}
+	return []*JSON{toNode(elementAux)}
 }
 func (element *JSON) GetNode(xpath string) *JSON {
 	nodes := element.GetNodes(xpath)
@@ -195,8 +450,11 @@ func (element *JSON) GetValueF64(xpath string) float64 {
 	return f
 }
 func (element *JSON) GetValueInt(xpath string) int {
-	i := element.GetValueF64(xpath)
-	return int(i)
+	v := element.GetValue(xpath)
+	if i, err := strconv.ParseInt(v, 10, 0); err == nil {
+		return int(i)
+	}
+	return int(element.GetValueF64(xpath))
 }
 func (element *JSON) GetValue(xpath string) string {
 	if xpath == "." {
@@ -213,15 +471,7 @@ func (element *JSON) GetValue(xpath string) string {
 func (element *JSON) GetObjectVals() map[string]*JSON {
 	nodes := map[string]*JSON{}
 	for key, value := range element.ObjectVals {
-		if node, ok := value.(*JSON); ok {
-			nodes[key] = node
-		} else {
-			nodes[key] = &JSON{
-				StringVal: stringify(value),
-				BoolVal:   false,
-				ValueType: 6,
-			}
-		}
+		nodes[key] = toNode(value)
 	}
 	return nodes
 }
@@ -229,258 +479,521 @@ func (element *JSON) GetArrayVals(index int64) []*JSON {
 	nodes := []*JSON{}
 	for i, a := range element.ArrayVals {
 		if index == math.MaxInt64 || int64(i) == index {
-			if node, ok := a.(*JSON); ok {
-				nodes = append(nodes, node)
-			} else {
-				nodes = append(nodes, &JSON{
-					StringVal: stringify(a),
-					BoolVal:   false,
-					ValueType: 6,
-				})
-			}
+			nodes = append(nodes, toNode(a))
 		}
 	}
 	return nodes
 }
+
+// Keys returns the property names of an object, in input order when the
+// parser preserves order and sorted otherwise. Properties added to ObjectVals
+// later follow the parsed ones, sorted.
+func (element *JSON) Keys() []string {
+	if element == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(element.ObjectVals))
+	for _, key := range element.keys {
+		if _, ok := element.ObjectVals[key]; ok { // not deleted since
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == len(element.ObjectVals) {
+		return keys
+	}
+	saved := len(keys)
+	known := make(map[string]bool, len(element.keys))
+	for _, key := range element.keys {
+		known[key] = true
+	}
+	for key := range element.ObjectVals {
+		if !known[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys[saved:])
+	return keys
+}
+
+// Len returns the number of properties of an object or elements of an array
+func (element *JSON) Len() int {
+	if element == nil {
+		return 0
+	}
+	if element.ValueType == Array {
+		return len(element.ArrayVals)
+	}
+	return len(element.ObjectVals)
+}
+
+// Pairs iterates over the properties of an object in the order of Keys
+func (element *JSON) Pairs() iter.Seq2[string, *JSON] {
+	return func(yield func(string, *JSON) bool) {
+		for _, key := range element.Keys() {
+			if !yield(key, toNode(element.ObjectVals[key])) {
+				return
+			}
+		}
+	}
+}
+
+// IsEmpty reports whether the element is missing, null or holds no values
 func (element *JSON) IsEmpty() bool {
-	if element == nil || (len(element.ArrayVals) == 0 && len(element.ObjectVals) == 0 && element.StringVal == "") {
+	if element == nil || element.ValueType == Null || (len(element.ArrayVals) == 0 && len(element.ObjectVals) == 0 && element.StringVal == "") {
 		return true
 	}
 	return false
 }
+
+// IsNull reports whether the element is a json null
+func (element *JSON) IsNull() bool {
+	return element != nil && element.ValueType == Null
+}
 func stringify(i interface{}) string {
 	if s, ok := i.(string); ok {
 		return s
 	} else if b, ok := i.(bool); ok {
 		return strconv.FormatBool(b)
-	} else if n, ok := i.(float64); ok {
-		return strconv.FormatFloat(n, 'f', 6, 64)
-	} else if n, ok := i.(int); ok {
-		return strconv.Itoa(n)
+	} else if n, ok := formatNumber(i); ok {
+		return n
 	}
 	return ""
 }
-func (element *JSON) pathIndex(path string) (string, int64) {
-	indexes := strings.Split(path, "[")
-	path = indexes[0]
-	if len(indexes) > 1 {
-		indexes := strings.Split(indexes[1], "]")
-		index, err := strconv.ParseInt(indexes[0], 10, 64)
-		if err != nil {
-			return path, math.MaxInt64
-		}
-		return path, index
+
+// toNode wraps a value of ObjectVals or ArrayVals as *JSON
+func toNode(i interface{}) *JSON {
+	switch v := i.(type) {
+	case *JSON:
+		return v
+	case bool:
+		return &JSON{StringVal: strconv.FormatBool(v), BoolVal: v, ValueType: Boolean}
+	case string:
+		return &JSON{StringVal: v, ValueType: String}
+	case nil:
+		return &JSON{ValueType: Null}
 	}
-	return path, math.MaxInt64
+	return &JSON{StringVal: stringify(i), ValueType: Number}
 }
 func (j *JsonParser) parse() {
 
-	defer close(j.resChan)
+	defer j.closeStream()
+
+	for res := j.next(); res != nil; res = j.next() {
+		if !j.sendRes(res) {
+			return
+		}
+	}
+
+}
+
+// next scans up to the next result. It returns nil at the end of input and
+// after an error result.
+func (j *JsonParser) next() *JSON {
+
+	if j.done {
+		return nil
+	}
+
+	if j.err != nil {
+		return j.fail(j.err)
+	}
 
 	var b byte
 	var err error
 
 	for {
-		b, err = j.readByte()
+
+		b, err = j.skipWS()
 
 		if err != nil {
-			return
+			if err != io.EOF || len(j.stack) > 0 { // truncated input
+				return j.fail(j.syntaxError(j.expected()))
+			}
+			j.done = true
+			return nil
 		}
 
-		if j.isWS(b) {
-			continue
-		}
+		switch j.state {
+		case scanKey, scanKeyOrEnd:
 
-		if b == '"' { // begining of possible json property
+			if b == '}' && j.state == scanKeyOrEnd {
+				j.pop()
+				break
+			}
 
-			isprop, err := j.getPropName()
+			if b != '"' {
+				return j.fail(j.syntaxError(j.expected()))
+			}
 
+			err = j.string()
 			if err != nil {
-				j.sendError()
-				return
+				return j.fail(err)
 			}
 
-			if isprop {
+			top := &j.stack[len(j.stack)-1]
+			top.key = append(top.key[:0], j.stringBytes()...)
 
-				b, err = j.skipWS()
-				if err != nil {
-					j.sendError()
-					return
-				}
+			b, err = j.skipWS()
+			if err != nil || b != ':' {
+				return j.fail(j.syntaxError("':'"))
+			}
+			j.state = scanValue
 
-				valType, typeErr := j.getValueType(b)
+		case scanValueOrEnd:
 
-				if typeErr != nil {
-					j.sendError()
-					return
-				}
+			if b == ']' {
+				j.pop()
+				break
+			}
 
-				if bytes.Equal(j.loopProp, j.scratch.bytes()) {
+			if res := j.scanValue(b); res != nil {
+				return res
+			}
 
-					switch valType {
-					case String:
+		case scanValue:
 
-						err = j.string()
+			if res := j.scanValue(b); res != nil {
+				return res
+			}
 
-						if err != nil {
-							j.sendError()
-							return
-						}
-						j.sendRes(&JSON{StringVal: j.scratch.string(), ValueType: String})
+		case scanCommaOrEnd:
 
-					case Array:
+			top := &j.stack[len(j.stack)-1]
 
-						success := j.loopArray()
-						if !success {
-							return
-						}
+			switch {
+			case b == ',' && top.isArray:
+				top.index++
+				j.state = scanValue
+			case b == ',':
+				j.state = scanKey
+			case b == ']' && top.isArray, b == '}' && !top.isArray:
+				j.pop()
+			default:
+				return j.fail(j.syntaxError(j.expected()))
+			}
 
-					case Object:
+		case scanDone:
 
-						res := &JSON{ObjectVals: map[string]interface{}{}, ValueType: Object}
-						j.getObjectTree(res)
-						j.sendRes(res)
-						if res.Err != nil {
-							return
-						}
+			if !j.ndjson { // only whitespace may follow the root value
+				return j.fail(j.syntaxError(j.expected()))
+			}
 
-					case Boolean:
+			if res := j.scanValue(b); res != nil {
+				return res
+			}
 
-						b, err := j.boolean()
-						if err != nil {
-							j.sendError()
-							return
-						}
-						j.sendRes(&JSON{BoolVal: b, ValueType: Boolean})
+		}
+	}
 
-					case Number:
+}
 
-						err = j.number(b)
+// fail stops parsing with err as the last result
+func (j *JsonParser) fail(err error) *JSON {
 
-						if err != nil {
-							j.sendError()
-							return
-						}
-						j.sendRes(&JSON{StringVal: j.scratch.string(), ValueType: Number})
+	j.done = true
+	return &JSON{Err: err, ValueType: Invalid}
 
-					case Null:
+}
 
-						err := j.null()
+// scanValue handles a value found while scanning the document. Loop values
+// are returned as results, others are skipped or descended into.
+func (j *JsonParser) scanValue(b byte) *JSON {
 
-						if err != nil {
-							j.sendError()
-							return
-						}
-						j.sendRes(&JSON{ValueType: Null})
+	valType, err := j.getValueType(b)
 
-					}
+	if err != nil {
+		return j.fail(err)
+	}
 
-				} else {
+	if n := len(j.stack); n > 0 && j.stack[n-1].loop != nil { // element of a looped array
 
-					if valType == String { // if valtype is string just skip it otherwise continue looking loopProp.
-						err = j.skipString()
-						if err != nil {
-							j.sendError()
-							return
-						}
-					}
+		if j.skipLoop() {
+			return j.skipLoopValue(valType)
+		}
 
-				}
+		return j.loopValue(j.stack[n-1].loop, valType, b)
+	}
+
+	if sel := j.match(); sel != nil {
+
+		if j.skipLoop() {
+			return j.skipLoopValue(valType)
+		}
+
+		if valType == Array && !(j.ndjson && len(j.stack) == 0) { // loop over the elements
+			j.push(true, sel)
+			return nil
+		}
+
+		return j.loopValue(sel, valType, b)
+	}
+
+	switch valType {
+	case Object, Array:
+
+		if !j.matchBelow() { // nothing to loop inside, skip it at once
+			if valType == Object {
+				err = j.skipArrayOrObject('{', '}')
+			} else {
+				err = j.skipArrayOrObject('[', ']')
 			}
+			break
 		}
+
+		j.push(valType == Array, nil)
+		return nil
+
+	case String:
+		err = j.skipString()
+	case Boolean:
+		_, err = j.boolean()
+	case Number:
+		err = j.number(b)
+	case Null:
+		err = j.null()
 	}
 
+	if err != nil {
+		return j.fail(err)
+	}
+
+	j.endValue()
+	return nil
+
 }
 
-func (j *JsonParser) sendRes(res *JSON) {
-	if j.isResArr {
-		j.scratch.addRes(res)
-	} else {
-		j.resChan <- res
+// skipLoopValue skips a loop value left out by SkipProps
+func (j *JsonParser) skipLoopValue(valType ValueType) *JSON {
+
+	if err := j.skipValue(valType); err != nil {
+		return j.fail(err)
 	}
+	j.endValue()
+	return nil
+
 }
 
-func (j *JsonParser) loopArray() bool {
+// loopValue reads a loop value as a result
+func (j *JsonParser) loopValue(sel *selector, valType ValueType, b byte) *JSON {
 
-	var b byte
-	var err error
+	if j.tee != nil { // b and the rest of the value are replaced
+		j.tee.drop()
+		j.tee.off = true
+		j.tee.write(b)
+	}
 
-	for {
+	if j.keepRoot != nil && !j.keepAll {
+		j.keep = j.keepRoot
+	}
 
-		b, err = j.skipWS()
+	var location string
+	if j.locations {
+		location = j.location()
+	}
+	res := j.getValue(valType, b)
+	res.Selector = sel.name
+	res.Location = location
+	j.keep = nil
 
-		if err != nil {
-			j.sendError()
-			return false
+	if j.tee != nil {
+		j.tee.off = false
+	}
+
+	if res.Err != nil {
+		j.done = true
+		return res
+	}
+
+	j.endValue()
+
+	if j.where != nil && !j.where.test(res, res) { // filtered out
+		if j.tee != nil {
+			j.tee.keep()
 		}
+		return nil
+	}
+	return res
 
-		if b == ']' {
+}
+
+// match returns the selector of the value at the current position if it is a loop value
+func (j *JsonParser) match() *selector {
+	for _, sel := range j.loops {
+		if sel.match(j.stack) {
+			return sel
+		}
+	}
+	return nil
+}
+
+// matchBelow reports whether any loop value can be inside the value at the current position
+func (j *JsonParser) matchBelow() bool {
+	for _, sel := range j.loops {
+		if sel.matchBelow(j.stack) {
 			return true
 		}
+	}
+	return false
+}
 
-		if b == ',' {
-			continue
+// expected describes what the scanner is looking for in its current state
+func (j *JsonParser) expected() string {
+
+	switch j.state {
+	case scanKey:
+		return "property name"
+	case scanKeyOrEnd:
+		return "property name or '}'"
+	case scanValueOrEnd:
+		return "value or ']'"
+	case scanCommaOrEnd:
+		if j.stack[len(j.stack)-1].isArray {
+			return "',' or ']'"
 		}
+		return "',' or '}'"
+	case scanDone:
+		return "end of input"
+	}
+	return "value"
 
-		valType, err := j.getValueType(b)
+}
 
-		if err != nil {
-			j.sendError()
-			return false
-		}
+// endValue moves the scanner past a completed value
+func (j *JsonParser) endValue() {
+	if len(j.stack) == 0 {
+		j.state = scanDone
+	} else {
+		j.state = scanCommaOrEnd
+	}
+}
 
-		switch valType {
-		case String:
+// push enters an object or array, reusing the key buffer of a previous frame
+func (j *JsonParser) push(isArray bool, loop *selector) {
 
-			err = j.string()
+	n := len(j.stack)
+	if n < cap(j.stack) {
+		j.stack = j.stack[:n+1]
+		j.stack[n] = pathFrame{key: j.stack[n].key[:0], isArray: isArray, loop: loop}
+	} else {
+		j.stack = append(j.stack, pathFrame{isArray: isArray, loop: loop})
+	}
 
-			if err != nil {
-				j.sendRes(&JSON{Err: err, ValueType: Invalid})
-				return false
-			}
-			j.sendRes(&JSON{StringVal: j.scratch.string(), ValueType: String})
-		case Array:
+	if isArray {
+		j.state = scanValueOrEnd
+	} else {
+		j.state = scanKeyOrEnd
+	}
 
-			res := &JSON{ObjectVals: map[string]interface{}{}, ValueType: Array}
-			j.getArrayTree(res)
-			j.sendRes(res)
+}
 
-		case Object:
+// pop leaves the innermost object or array
+func (j *JsonParser) pop() {
+	j.stack = j.stack[:len(j.stack)-1]
+	j.endValue()
+}
 
-			res := &JSON{ObjectVals: map[string]interface{}{}, ValueType: Object}
-			j.getObjectTree(res)
-			j.sendRes(res)
+// getValue reads a complete value whose first byte is b
+func (j *JsonParser) getValue(valType ValueType, b byte) *JSON {
 
-		case Boolean:
+	var err error
 
-			b, err := j.boolean()
-			if err != nil {
-				j.sendError()
-				return false
-			}
-			j.sendRes(&JSON{BoolVal: b, ValueType: Boolean})
+	switch valType {
+	case String:
 
-		case Number:
+		err = j.string()
+		if err == nil {
+			return &JSON{StringVal: j.stringValue(), ValueType: String}
+		}
 
-			err = j.number(b)
-			if err != nil {
-				return false
-			}
-			j.sendRes(&JSON{StringVal: j.scratch.string(), ValueType: Number})
+	case Array:
 
-		case Null:
+		res := &JSON{ObjectVals: map[string]interface{}{}, ValueType: Array}
+		j.getArrayTree(res)
+		return res
 
-			err := j.null()
+	case Object:
 
-			if err != nil {
-				return false
-			}
-			j.sendRes(&JSON{ValueType: Null})
+		res := &JSON{ObjectVals: map[string]interface{}{}, ValueType: Object}
+		j.getObjectTree(res)
+		return res
+
+	case Boolean:
+
+		var b bool
+		b, err = j.boolean()
+		if err == nil {
+			return &JSON{BoolVal: b, ValueType: Boolean}
+		}
+
+	case Number:
 
+		err = j.number(b)
+		if err == nil {
+			return &JSON{StringVal: j.scratch.string(), ValueType: Number}
+		}
+
+	case Null:
+
+		err = j.null()
+		if err == nil {
+			return &JSON{ValueType: Null}
 		}
 
 	}
 
+	return &JSON{Err: err, ValueType: Invalid}
+
+}
+
+// sendRes returns false when the stream context is done and parsing must stop
+func (j *JsonParser) sendRes(res *JSON) bool {
+
+	if j.isResArr {
+		j.scratch.addRes(res)
+		return true
+	}
+
+	if j.ctx == nil {
+		j.resChan <- res
+		return true
+	}
+
+	if j.ctx.Err() != nil {
+		j.cancelled = true
+		return false
+	}
+
+	select {
+	case j.resChan <- res:
+		return true
+	case <-j.ctx.Done():
+		j.cancelled = true
+		return false
+	}
+
+}
+
+// closeStream ends the result channel, with the context error as the final
+// result if the stream was cancelled
+func (j *JsonParser) closeStream() {
+
+	if j.cancelled {
+		res := &JSON{Err: j.ctx.Err(), ValueType: Invalid}
+		for sent := false; !sent; {
+			select {
+			case j.resChan <- res:
+				sent = true
+			default:
+				select { // nobody may be reading anymore, drop a stale result to make room
+				case <-j.resChan:
+				default:
+				}
+			}
+		}
+	}
+
+	close(j.resChan)
+
 }
 
 func (j *JsonParser) getObjectTree(res *JSON) {
@@ -489,34 +1002,40 @@ func (j *JsonParser) getObjectTree(res *JSON) {
 		return
 	}
 
+	j.enterTree(false)
+	defer j.leaveTree()
+
 	var b byte
 	var err error
 	for {
 
-		b, err = j.readByte()
+		b, err = j.skipWS()
 
 		if err != nil {
-			res.Err = err
+			res.Err = j.syntaxError("property name or '}'")
 			return
 		}
 
-		if j.isWS(b) {
-			continue
-		}
-
 		if b == '"' { // begining of json property
 
-			_, err := j.getPropName() // first variable ommited because inside object there can't be string item
-			prop := j.scratch.string()
+			j.propOffset, j.propLine, j.propColumn = j.TotalReadSize-1, j.line+1, j.column
+
+			isprop, err := j.getPropName()
+			prop := string(j.stringBytes()) // keys are copied, only values may reference the input
 
 			if err != nil {
 				res.Err = err
 				return
 			}
 
+			if !isprop { // inside object there can't be string item
+				res.Err = j.syntaxError("':'")
+				return
+			}
+
 			b, err = j.skipWS()
 			if err != nil {
-				res.Err = j.defaultError()
+				res.Err = j.syntaxError("value")
 				return
 			}
 
@@ -527,19 +1046,18 @@ func (j *JsonParser) getObjectTree(res *JSON) {
 				return
 			}
 
+			if j.skip(prop) {
+				err = j.skipValue(valType)
+				if err != nil {
+					res.Err = err
+					return
+				}
+				continue
+			}
+
 			switch valType {
 			case String:
 
-				if ok := j.skipProps[prop]; ok {
-					err = j.skipString()
-
-					if err != nil {
-						res.Err = err
-						return
-					}
-					break
-				}
-
 				err = j.string()
 
 				if err != nil {
@@ -547,46 +1065,34 @@ func (j *JsonParser) getObjectTree(res *JSON) {
 					return
 				}
 
-				res.ObjectVals[prop] = j.scratch.string()
+				j.setProp(res, prop, j.stringValue())
 
 			case Array:
 
-				if ok := j.skipProps[prop]; ok {
-					err = j.skipArrayOrObject('[', ']')
-
-					if err != nil {
-						res.Err = err
-						return
-					}
-					break
-				}
 				r := &JSON{ValueType: Array}
+				keep := j.keep
+				j.keep = j.keepNext
 				j.getArrayTree(r)
+				j.keep = keep
 				if r.Err != nil {
 					res.Err = r.Err
 					return
 				}
-				res.ObjectVals[prop] = r
+				j.setProp(res, prop, r)
 
 			case Object:
 
-				if ok := j.skipProps[prop]; ok {
-					err = j.skipArrayOrObject('{', '}')
-
-					if err != nil {
-						res.Err = err
-						return
-					}
-					break
-				}
 				r := &JSON{ObjectVals: map[string]interface{}{}, ValueType: Object}
+				keep := j.keep
+				j.keep = j.keepNext
 				j.getObjectTree(r)
+				j.keep = keep
 
 				if r.Err != nil {
 					res.Err = r.Err
 					return
 				}
-				res.ObjectVals[prop] = r
+				j.setProp(res, prop, r)
 
 			case Boolean:
 
@@ -597,10 +1103,7 @@ func (j *JsonParser) getObjectTree(res *JSON) {
 					return
 				}
 
-				// rest of the skip since they are small we just don't include in the result
-				if ok := j.skipProps[prop]; !ok {
-					res.ObjectVals[prop] = b
-				}
+				j.setProp(res, prop, b)
 
 			case Number:
 
@@ -611,9 +1114,7 @@ func (j *JsonParser) getObjectTree(res *JSON) {
 					return
 				}
 
-				if ok := j.skipProps[prop]; !ok {
-					res.ObjectVals[prop] = j.scratch.string()
-				}
+				j.setProp(res, prop, j.numberValue())
 
 			case Null:
 
@@ -623,12 +1124,14 @@ func (j *JsonParser) getObjectTree(res *JSON) {
 					return
 				}
 
-				if ok := j.skipProps[prop]; !ok {
-					res.ObjectVals[prop] = ""
-				}
+				j.setProp(res, prop, &JSON{ValueType: Null})
 
 			}
 
+			if res.Err != nil { // duplicate key
+				return
+			}
+
 		} else if b == ',' {
 
 			continue
@@ -639,7 +1142,7 @@ func (j *JsonParser) getObjectTree(res *JSON) {
 
 		} else { // invalid end
 
-			res.Err = j.defaultError()
+			res.Err = j.syntaxError("property name, ',' or '}'")
 			return
 
 		}
@@ -648,28 +1151,57 @@ func (j *JsonParser) getObjectTree(res *JSON) {
 
 }
 
+// setProp stores a property of an object, remembering the key order if needed
+// and applying the duplicate key policy
+func (j *JsonParser) setProp(res *JSON, prop string, value interface{}) {
+
+	old, dup := res.ObjectVals[prop]
+
+	if !dup {
+		if j.preserveOrder {
+			res.keys = append(res.keys, prop)
+		}
+		res.ObjectVals[prop] = value
+		return
+	}
+
+	switch j.duplicateKeys {
+	case DuplicateFirstWins:
+	case DuplicateError:
+		res.Err = &DuplicateKeyError{Key: prop, Offset: j.propOffset, Line: j.propLine, Column: j.propColumn}
+	case DuplicateCollect:
+		if c, ok := old.(*JSON); ok && c.collected {
+			c.ArrayVals = append(c.ArrayVals, value)
+		} else {
+			res.ObjectVals[prop] = &JSON{ArrayVals: []interface{}{old, value}, ValueType: Array, collected: true}
+		}
+	default:
+		res.ObjectVals[prop] = value
+	}
+
+}
+
 func (j *JsonParser) getArrayTree(res *JSON) {
 
 	if res.Err != nil {
 		return
 	}
 
+	j.enterTree(true)
+	defer j.leaveTree()
+
 	var b byte
 	var err error
 
 	for {
 
-		b, err = j.readByte()
+		b, err = j.skipWS()
 
 		if err != nil {
-			res.Err = err
+			res.Err = j.syntaxError("value, ',' or ']'")
 			return
 		}
 
-		if j.isWS(b) {
-			continue
-		}
-
 		if b == ',' {
 			continue
 		}
@@ -684,6 +1216,20 @@ func (j *JsonParser) getArrayTree(res *JSON) {
 			res.Err = err
 			return
 		}
+
+		if j.skipRules != nil {
+			j.treePath[len(j.treePath)-1].index++
+
+			if j.skipElement() {
+				err = j.skipValue(valType)
+				if err != nil {
+					res.Err = err
+					return
+				}
+				continue
+			}
+		}
+
 		switch valType {
 		case String:
 
@@ -693,7 +1239,7 @@ func (j *JsonParser) getArrayTree(res *JSON) {
 				res.Err = err
 				return
 			}
-			res.ArrayVals = append(res.ArrayVals, j.scratch.string())
+			res.ArrayVals = append(res.ArrayVals, j.stringValue())
 
 		case Array:
 
@@ -732,7 +1278,7 @@ func (j *JsonParser) getArrayTree(res *JSON) {
 				res.Err = err
 				return
 			}
-			res.ArrayVals = append(res.ArrayVals, j.scratch.string())
+			res.ArrayVals = append(res.ArrayVals, j.numberValue())
 
 		case Null:
 
@@ -743,7 +1289,7 @@ func (j *JsonParser) getArrayTree(res *JSON) {
 				return
 			}
 
-			res.ArrayVals = append(res.ArrayVals, "")
+			res.ArrayVals = append(res.ArrayVals, &JSON{ValueType: Null})
 
 		}
 
@@ -758,41 +1304,48 @@ func (j *JsonParser) number(first byte) error {
 	j.scratch.reset()
 	j.scratch.add(first)
 
+	state := numberStep(numBegin, first)
+	state = j.plainNumber(state)
+
 	for {
 
 		c, err = j.readByte()
 
-		if err != nil {
-			return j.defaultError()
+		if err == io.EOF { // end of a root value
+			if !j.lenientNumbers && !numberComplete(state) {
+				return j.syntaxError(numberExpected(state))
+			}
+			return nil
 		}
 
-		if j.isWS(c) {
+		if err != nil {
+			return j.syntaxError("number")
+		}
 
-			c, err = j.skipWS()
+		if j.isWS(c) || c == ',' || c == '}' || c == ']' {
 
-			if err != nil {
-				return j.defaultError()
+			if !j.lenientNumbers && !numberComplete(state) {
+				return j.syntaxError(numberExpected(state))
 			}
 
-			if !(c == ',' || c == '}' || c == ']') {
-				return j.defaultError()
+			if j.isWS(c) {
+				return j.endScalar()
 			}
+
 			err := j.unreadByte()
 			if err != nil {
-				return j.defaultError()
+				return j.syntaxError("number")
 			}
 
 			return nil
 		}
 
-		if c == ',' || c == '}' || c == ']' {
-
-			err := j.unreadByte()
-			if err != nil {
-				return j.defaultError()
+		if !j.lenientNumbers {
+			next := numberStep(state, c)
+			if next == numInvalid {
+				return j.syntaxError(numberExpected(state))
 			}
-
-			return nil
+			state = next
 		}
 
 		j.scratch.add(c)
@@ -809,7 +1362,7 @@ func (j *JsonParser) boolean() (bool, error) {
 	c, err = j.readByte()
 
 	if err != nil {
-		return false, j.defaultError()
+		return false, j.syntaxError("true or false")
 	}
 
 	// true
@@ -817,26 +1370,17 @@ func (j *JsonParser) boolean() (bool, error) {
 		c, err = j.readByte()
 
 		if err != nil {
-			return false, j.defaultError()
+			return false, j.syntaxError("true or false")
 		}
 		if c == 'u' {
 			c, err = j.readByte()
 
 			if err != nil {
-				return false, j.defaultError()
+				return false, j.syntaxError("true or false")
 			}
 			if c == 'e' {
-				// check last
-				c, err = j.skipWS()
-				if err != nil {
-					return false, j.defaultError()
-				}
-				if !(c == ',' || c == '}' || c == ']') {
-					return false, j.defaultError()
-				}
-				err := j.unreadByte()
-				if err != nil {
-					return false, j.defaultError()
+				if err = j.endScalar(); err != nil {
+					return false, err
 				}
 
 				return true, nil
@@ -849,32 +1393,23 @@ func (j *JsonParser) boolean() (bool, error) {
 		c, err = j.readByte()
 
 		if err != nil {
-			return false, j.defaultError()
+			return false, j.syntaxError("true or false")
 		}
 		if c == 'l' {
 			c, err = j.readByte()
 
 			if err != nil {
-				return false, j.defaultError()
+				return false, j.syntaxError("true or false")
 			}
 			if c == 's' {
 				c, err = j.readByte()
 
 				if err != nil {
-					return false, j.defaultError()
+					return false, j.syntaxError("true or false")
 				}
 				if c == 'e' {
-					// check last
-					c, err = j.skipWS()
-					if err != nil {
-						return false, j.defaultError()
-					}
-					if !(c == ',' || c == '}' || c == ']') {
-						return false, j.defaultError()
-					}
-					err := j.unreadByte()
-					if err != nil {
-						return false, j.defaultError()
+					if err = j.endScalar(); err != nil {
+						return false, err
 					}
 
 					return false, nil
@@ -883,7 +1418,7 @@ func (j *JsonParser) boolean() (bool, error) {
 		}
 	}
 
-	return false, j.defaultError()
+	return false, j.syntaxError("true or false")
 
 }
 
@@ -895,7 +1430,7 @@ func (j *JsonParser) null() error {
 	c, err = j.readByte()
 
 	if err != nil {
-		return j.defaultError()
+		return j.syntaxError("null")
 	}
 
 	// true
@@ -903,63 +1438,207 @@ func (j *JsonParser) null() error {
 		c, err = j.readByte()
 
 		if err != nil {
-			return j.defaultError()
+			return j.syntaxError("null")
 		}
 
 		if c == 'l' {
 			c, err = j.readByte()
 
 			if err != nil {
-				return j.defaultError()
+				return j.syntaxError("null")
 			}
 			if c == 'l' {
-				// check last
-				c, err = j.skipWS()
-				if err != nil {
-					return j.defaultError()
-				}
+				return j.endScalar()
+			}
+		}
+	}
 
-				if !(c == ',' || c == '}' || c == ']') {
-					return j.defaultError()
-				}
+	return j.syntaxError("null")
+}
 
-				err := j.unreadByte()
-				if err != nil {
-					return j.defaultError()
-				}
+// enterTree starts reading an object or array of a loop value
+func (j *JsonParser) enterTree(isArray bool) {
 
-				return nil
-			}
+	j.treeDepth++
+
+	if j.skipRules != nil {
+		n := len(j.treePath)
+		if n < cap(j.treePath) {
+			j.treePath = j.treePath[:n+1]
+			j.treePath[n] = pathFrame{key: j.treePath[n].key[:0], index: -1, isArray: isArray}
+		} else {
+			j.treePath = append(j.treePath, pathFrame{index: -1, isArray: isArray})
 		}
 	}
 
-	return j.defaultError()
 }
 
-func (j *JsonParser) skipString() error {
+func (j *JsonParser) leaveTree() {
 
-	var c byte
-	var prev byte
-	var prevPrev byte
-	var err error
-	for {
+	j.treeDepth--
 
-		c, err = j.readByte()
+	if j.skipRules != nil {
+		j.treePath = j.treePath[:len(j.treePath)-1]
+	}
+
+}
+
+// skipLoop reports whether the loop value at the current position is left out
+func (j *JsonParser) skipLoop() bool {
+
+	n := len(j.stack)
+	if n == 0 {
+		return false
+	}
+	if top := &j.stack[n-1]; !top.isArray && j.skipProps[string(top.key)] {
+		return true
+	}
+	for _, rule := range j.skipRules {
+		if rule.match(j.stack) {
+			return true
+		}
+	}
+	return false
+
+}
+
+// skipElement reports whether an element of the array being read is left out
+func (j *JsonParser) skipElement() bool {
+
+	j.pathBuf = append(append(j.pathBuf[:0], j.stack...), j.treePath...)
+	for _, rule := range j.skipRules {
+		if rule.match(j.pathBuf) {
+			return true
+		}
+	}
+	return false
+
+}
+
+// skip reports whether a property of the object being read is left out
+func (j *JsonParser) skip(prop string) bool {
+
+	if j.keep != nil {
+		next, ok := j.keep.children[prop]
+		if !ok {
+			return true
+		}
+		j.keepNext = next
+	} else {
+		j.keepNext = nil
+	}
+
+	if j.skipProps[prop] {
+		return true
+	}
+	if j.skipRules == nil {
+		return false
+	}
+
+	top := &j.treePath[len(j.treePath)-1]
+	top.key = append(top.key[:0], prop...)
+
+	j.pathBuf = append(append(j.pathBuf[:0], j.stack...), j.treePath...)
+	for _, rule := range j.skipRules {
+		if rule.match(j.pathBuf) {
+			return true
+		}
+	}
+	return false
+
+}
+
+// endScalar checks that a number, boolean or null is followed by a delimiter
+// or the end of input and leaves the delimiter unread
+func (j *JsonParser) endScalar() error {
+
+	c, err := j.skipWS()
+
+	if err == io.EOF { // end of a root value
+		return nil
+	}
+
+	if err != nil {
+		return j.syntaxError("',', '}' or ']'")
+	}
+
+	if !(c == ',' || c == '}' || c == ']') && !(j.ndjson && len(j.stack) == 0 && j.treeDepth == 0) {
+		return j.syntaxError("',', '}' or ']'")
+	}
+
+	err = j.unreadByte()
+	if err != nil {
+		return j.syntaxError("',', '}' or ']'")
+	}
+
+	return nil
+
+}
+
+// skipValue skips a value whose first byte is read without storing it. Only
+// strings and the nesting of objects and arrays are checked.
+func (j *JsonParser) skipValue(valType ValueType) error {
+
+	switch valType {
+	case String:
+		return j.skipString()
+	case Object:
+		return j.skipArrayOrObject('{', '}')
+	case Array:
+		return j.skipArrayOrObject('[', ']')
+	}
+	return j.skipScalar()
 
+}
+
+// skipScalar skips the rest of a number, boolean or null
+func (j *JsonParser) skipScalar() error {
+
+	for {
+		j.plainScalar()
+		c, err := j.readByte()
+		if err == io.EOF { // end of a root value
+			return nil
+		}
 		if err != nil {
-			return j.defaultError()
+			return j.syntaxError("',', '}' or ']'")
 		}
+		if c == ',' || c == '}' || c == ']' || j.isWS(c) {
+			break
+		}
+	}
 
-		if c == '"' {
+	if err := j.unreadByte(); err != nil {
+		return j.syntaxError("',', '}' or ']'")
+	}
+	return j.endScalar()
 
-			if !(prev == '\\' && prevPrev != '\\') { // escape check
-				return nil
-			}
+}
+
+func (j *JsonParser) skipString() error {
+
+	for {
 
+		buf, err := j.buffered()
+		if err != nil {
+			return j.syntaxError("closing '\"'")
 		}
 
-		prevPrev = prev
-		prev = c
+		i := indexAny3(buf, '"', '\\', '\\')
+		switch {
+		case i == len(buf):
+			j.consume(buf)
+		case buf[i] == '"':
+			j.consume(buf[:i+1])
+			return nil
+		case i+1 < len(buf): // skip the escaped byte too
+			j.consume(buf[:i+2])
+		default:
+			j.consume(buf)
+			if _, err = j.readByte(); err != nil {
+				return j.syntaxError("escape character")
+			}
+		}
 
 	}
 
@@ -967,17 +1646,23 @@ func (j *JsonParser) skipString() error {
 
 func (j *JsonParser) skipArrayOrObject(start byte, end byte) error {
 
-	var c byte
-	var err error
 	var depth = 1
 	for {
 
-		c, err = j.readByte()
-
+		buf, err := j.buffered()
 		if err != nil {
-			return j.defaultError()
+			return j.syntaxError(fmt.Sprintf("closing '%c'", end))
+		}
+
+		i := indexAny3(buf, '"', start, end)
+		if i == len(buf) {
+			j.consume(buf)
+			continue
 		}
 
+		c := buf[i]
+		j.consume(buf[:i+1])
+
 		switch c {
 		case '"':
 			err = j.skipString() // this is needed because string can contain [ or ]
@@ -1017,7 +1702,7 @@ func (j *JsonParser) getValueType(c byte) (ValueType, error) {
 		return Object, nil
 	}
 
-	return Invalid, j.defaultError()
+	return Invalid, j.syntaxError("value")
 
 }
 
@@ -1059,33 +1744,54 @@ func (j *JsonParser) isWS(in byte) bool {
 // skips WS and read first non WS
 func (j *JsonParser) skipWS() (byte, error) {
 
-	var b byte
-	var err error
-	for {
+	b, err := j.readByte()
+	for err == nil && isSpace(b) {
+		j.plainSpace() // the rest of a run of indentation at once
 		b, err = j.readByte()
-		if err != nil {
-			return 0, err
-		}
-		if b == ' ' || b == '\n' || b == '\t' || b == '\r' {
-			continue
-		} else {
-			return b, nil
-		}
 	}
+	if err != nil {
+		return 0, err
+	}
+	return b, nil
 
 }
 
 func (j *JsonParser) readByte() (byte, error) {
 
+	if j.ctx != nil && j.TotalReadSize >= j.ctxCheck { // check now and then while skipping large content
+		j.ctxCheck = j.TotalReadSize + 65536
+		if err := j.ctx.Err(); err != nil {
+			j.readErr = err
+			j.cancelled = true
+			return 0, err
+		}
+	}
+
 	by, err := j.reader.ReadByte()
 
+	if err != nil {
+		j.readErr = err
+		return 0, err
+	}
+
+	if j.tee != nil {
+		j.tee.write(by)
+	}
+
+	j.recent[j.TotalReadSize%uint64(len(j.recent))] = by
+
 	j.TotalReadSize = j.TotalReadSize + 1
 
 	j.lastReadSize = 1
 
-	if err != nil {
-		return 0, err
+	if by == '\n' {
+		j.line++
+		j.prevColumn = j.column
+		j.column = 0
+	} else {
+		j.column++
 	}
+
 	return by, nil
 
 }
@@ -1096,70 +1802,62 @@ func (j *JsonParser) unreadByte() error {
 	if err != nil {
 		return err
 	}
+	if j.tee != nil {
+		j.tee.drop()
+	}
 	j.TotalReadSize = j.TotalReadSize - 1
-	return nil
-
-}
-
-func (j *JsonParser) sendError() {
-	err := fmt.Errorf("Invalid json")
-	if j.isResArr {
-		j.scratch.addRes(&JSON{Err: err, ValueType: Invalid})
+	if j.recent[j.TotalReadSize%uint64(len(j.recent))] == '\n' {
+		j.line--
+		j.column = j.prevColumn
 	} else {
-		j.resChan <- &JSON{Err: err, ValueType: Invalid}
+		j.column--
 	}
+	return nil
+
 }
 
 func (j *JsonParser) resultError() *JSON {
 
-	return &JSON{Err: j.defaultError(), ValueType: Invalid}
-
-}
+	return &JSON{Err: j.syntaxError("value"), ValueType: Invalid}
 
-func (j *JsonParser) defaultError() error {
-	err := fmt.Errorf("Invalid json")
-	return err
 }
 
 // based on https://github.com/bcicen/jstream
 func (j *JsonParser) string() error {
 
 	j.scratch.reset()
+	j.strStart, j.strEnd = j.TotalReadSize, j.TotalReadSize
+	j.zeroCopy = j.data != nil
 
 	var err error
 	var c byte
 
-	c, err = j.readByte()
-	if err != nil {
+scan:
+	for {
+		j.plainString() // bulk copy up to the next special byte
+
+		c, err = j.readByte()
 		if err != nil {
-			return j.defaultError()
+			return j.syntaxError("closing '\"'")
 		}
-	}
 
-scan:
-	for {
 		switch {
 		case c == '"':
+			j.strEnd = j.TotalReadSize - 1
 			return nil
 		case c == '\\':
+			j.copyString()
 			c, err = j.readByte()
 			if err != nil {
-				if err != nil {
-					return j.defaultError()
-				}
+				return j.syntaxError("escape character")
 			}
 			goto scan_esc
 		case c < 0x20:
-			return j.defaultError()
-			// Coerce to well-formed UTF-8.
+			return j.syntaxError("escaped control character")
 
 		}
-		j.scratch.add(c)
-		c, err = j.readByte()
-		if err != nil {
-			if err != nil {
-				return j.defaultError()
-			}
+		if !j.zeroCopy {
+			j.scratch.add(c)
 		}
 	}
 
@@ -1180,15 +1878,7 @@ scan_esc:
 	case 't':
 		j.scratch.add('\t')
 	default:
-		//err := fmt.Errorf("error in string escape code")
-		return j.defaultError()
-	}
-
-	c, err = j.readByte()
-	if err != nil {
-		if err != nil {
-			return j.defaultError()
-		}
+		return j.syntaxError("escape character")
 	}
 
 	goto scan
@@ -1196,28 +1886,31 @@ scan_esc:
 scan_u:
 	r := j.u4()
 	if r < 0 {
-		//err := fmt.Errorf("in unicode escape sequence")
-		return j.defaultError()
+		return j.syntaxError("four hex digits")
+	}
+
+	if !utf16.IsSurrogate(r) {
+		j.scratch.addRune(r)
+		goto scan
 	}
 
 	// check for proceeding surrogate pair
 	c, err = j.readByte()
 	if err != nil {
-		if err != nil {
-			return j.defaultError()
-		}
+		return j.syntaxError("closing '\"'")
 	}
 
-	if !utf16.IsSurrogate(r) || c != '\\' {
+	if c != '\\' {
+		if err = j.unreadByte(); err != nil {
+			return j.syntaxError("closing '\"'")
+		}
 		j.scratch.addRune(r)
 		goto scan
 	}
 
 	c, err = j.readByte()
 	if err != nil {
-		if err != nil {
-			return j.defaultError()
-		}
+		return j.syntaxError("closing '\"'")
 	}
 
 	if c != 'u' {
@@ -1227,19 +1920,12 @@ scan_u:
 
 	r2 := j.u4()
 	if r2 < 0 {
-		return j.defaultError()
+		return j.syntaxError("four hex digits")
 	}
 
 	// write surrogate pair
 	j.scratch.addRune(utf16.DecodeRune(r, r2))
 
-	c, err = j.readByte()
-	if err != nil {
-		if err != nil {
-			return j.defaultError()
-		}
-	}
-
 	goto scan
 }
 
@@ -3,11 +3,21 @@ package jsparser
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 var minify bool
@@ -845,6 +855,1435 @@ func Benchmark2(b *testing.B) {
 	}
 }
 
+func Benchmark3(b *testing.B) {
+
+	for n := 0; n < b.N; n++ {
+		p := getparser("a").SkipProps([]string{"a11"})
+		for p.Next() {
+			nothing(p.Value())
+		}
+	}
+}
+
 func nothing(j *JSON) {
 
 }
+
+func TestLoopPath(t *testing.T) {
+
+	const doc = `{"meta":{"items":["skip me"]},"data":{"items":[{"id":1},{"id":2}],"other":{"items":[3]}}}`
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	p := NewJSONPathParser(br, "$.data.items")
+	var results []*JSON
+	for _, json := range allResult(p) {
+		if json.Err != nil {
+			t.Fatal(json.Err)
+		}
+		results = append(results, json)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, found %d", len(results))
+	}
+	if results[1].GetValue("id") != "2" {
+		t.Errorf("Unexpected result %v", results[1].ObjectVals)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	p = NewJSONParser(br, "items")
+	if res := allResult(p); len(res) != 4 {
+		t.Errorf("Bare property name must match at any depth, found %d results", len(res))
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":[{"b":"x"},{"b":"y"}]}`))
+	p = NewJSONPathParser(br, "a[1].b")
+	res := allResult(p)
+	if len(res) != 1 || res[0].StringVal != "y" {
+		t.Errorf("Unexpected result for indexed path %v", res)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	p = NewJSONPathParser(br, "$.data..items")
+	if res := allResult(p); len(res) != 1 || res[0].Err == nil {
+		t.Error("Invalid loop path must return an error")
+	}
+}
+
+func TestMultipleLoops(t *testing.T) {
+
+	const doc = `{"orders":[{"id":1},{"id":2}],"customers":[{"id":3}],"meta":{"invoices":{"id":4}}}`
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	p := NewJSONParser(br, "orders").LoopProps([]string{"customers"}).LoopPaths([]string{"$.meta.invoices"})
+
+	counts := map[string]int{}
+	for _, json := range allResult(p) {
+		if json.Err != nil {
+			t.Fatal(json.Err)
+		}
+		counts[json.Selector]++
+	}
+
+	expected := map[string]int{"orders": 2, "customers": 1, "$.meta.invoices": 1}
+	for selector, count := range expected {
+		if counts[selector] != count {
+			t.Errorf("Selector %s \n\t Expected: %d \n\t Found: %d", selector, count, counts[selector])
+		}
+	}
+}
+
+func TestRoot(t *testing.T) {
+
+	br := bufio.NewReader(strings.NewReader(` [{"name":"a"}, {"name":"b"}, "c", 1, null] `))
+	p := NewJSONParser(br, "")
+	var results []*JSON
+	for _, json := range allResult(p) {
+		if json.Err != nil {
+			t.Fatal(json.Err)
+		}
+		results = append(results, json)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Root array elements must be streamed, found %d results", len(results))
+	}
+	if results[1].GetValue("name") != "b" || results[2].StringVal != "c" || results[4].ValueType != Null {
+		t.Error("Unexpected root array elements")
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"name":"a"}`))
+	p = NewJSONParser(br, "$")
+	res := allResult(p)
+	if len(res) != 1 || res[0].GetValue("name") != "a" {
+		t.Error("Root object must be returned as a single result")
+	}
+
+	br = bufio.NewReader(strings.NewReader(`42`))
+	p = NewJSONParser(br, "")
+	res = allResult(p)
+	if len(res) != 1 || res[0].Err != nil || res[0].StringVal != "42" {
+		t.Error("Root number must be returned as a single result")
+	}
+
+	br = bufio.NewReader(strings.NewReader(`[{"name":"a"}`))
+	p = NewJSONParser(br, "")
+	res = allResult(p)
+	if len(res) != 2 || res[1].Err == nil {
+		t.Error("Truncated root array must end with an error")
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+
+	const lines = "{\"id\":1,\"tags\":[\"x\"],\"body\":\"long\"}\n{\"id\":2,\"body\":\"long\"}\n[1,2]\n\"s\" 3\nnull\n"
+
+	br := bufio.NewReader(strings.NewReader(lines))
+	p := NewJSONParser(br, "").NDJSON().SkipProps([]string{"body"})
+	var results []*JSON
+	for _, json := range allResult(p) {
+		if json.Err != nil {
+			t.Fatal(json.Err)
+		}
+		results = append(results, json)
+	}
+	if len(results) != 6 {
+		t.Fatalf("Expected 6 results, found %d", len(results))
+	}
+	if results[1].GetValue("id") != "2" || results[1].GetValue("body") != "" {
+		t.Error("Unexpected second line")
+	}
+	if results[2].ValueType != Array || len(results[2].ArrayVals) != 2 {
+		t.Error("Root arrays must be returned whole")
+	}
+	if results[4].StringVal != "3" || results[5].ValueType != Null {
+		t.Error("Unexpected scalar lines")
+	}
+
+	br = bufio.NewReader(strings.NewReader(lines))
+	p = NewJSONParser(br, "tags").NDJSON()
+	if res := allResult(p); len(res) != 1 || res[0].StringVal != "x" {
+		t.Error("Loop property must be matched inside each line")
+	}
+
+	br = bufio.NewReader(strings.NewReader("{\"id\":1}\n{\"id\":2}"))
+	p = NewJSONParser(br, "")
+	if res := allResult(p); len(res) != 2 || res[1].Err == nil {
+		t.Error("Concatenated values must be an error without NDJSON mode")
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+
+	br := bufio.NewReader(strings.NewReader("{\"list\":[\n  {\"a\": 1},\n  {\"a\": x}\n]}"))
+	p := NewJSONParser(br, "list")
+	res := allResult(p)
+
+	var serr *SyntaxError
+	if len(res) != 2 || !errors.As(res[1].Err, &serr) {
+		t.Fatalf("SyntaxError expected, found %v", res)
+	}
+	if serr.Line != 3 || serr.Column != 9 || serr.Offset != 30 || serr.Byte != 'x' || serr.EOF {
+		t.Errorf("Unexpected error position %+v", serr)
+	}
+	if !strings.Contains(serr.Context, `{"a": x}`) {
+		t.Errorf("Unexpected error context %q", serr.Context)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"list":[{"a": "trunc`))
+	p = NewJSONParser(br, "list")
+	res = allResult(p)
+	if len(res) != 1 || !errors.As(res[0].Err, &serr) || !serr.EOF {
+		t.Errorf("Unexpected end of input error expected, found %v", res)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"s":"bad \q escape"}`))
+	p = NewJSONParser(br, "s")
+	res = allResult(p)
+	if len(res) != 1 || !errors.As(res[0].Err, &serr) || serr.Expected != "escape character" || serr.Byte != 'q' {
+		t.Errorf("Escape error expected, found %v", res)
+	}
+}
+
+func TestStreamContext(t *testing.T) {
+
+	var sb strings.Builder
+	sb.WriteString(`{"list":[`)
+	for i := 0; i < 10000; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(`{"a":1}`)
+	}
+	sb.WriteString(`]}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	br := bufio.NewReader(strings.NewReader(sb.String()))
+	p := NewJSONParser(br, "list")
+
+	ch := p.StreamContext(ctx)
+	if json := <-ch; json.Err != nil {
+		t.Fatal(json.Err)
+	}
+	cancel()
+
+	count := 1
+	var last *JSON
+	for json := range ch {
+		last = json
+		count++
+	}
+	if count >= 10000 {
+		t.Error("Parsing must stop when the context is cancelled")
+	}
+	if last == nil || !errors.Is(last.Err, context.Canceled) {
+		t.Errorf("Context error expected as the final result, found %v", last)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	br = bufio.NewReader(strings.NewReader(sb.String()))
+	p = NewJSONParser(br, "list")
+	for json := range p.StreamContext(ctx) {
+		last = json
+	}
+	if !errors.Is(last.Err, context.Canceled) {
+		t.Errorf("Context error expected, found %v", last.Err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	unblock := make(chan struct{})
+	r := io.MultiReader(strings.NewReader(`{"list":[1,2,3]`), &blockedReader{unblock, strings.NewReader(`}`)})
+	ch = NewJSONParser(bufio.NewReader(r), "list").StreamContext(ctx)
+	for n := 0; n < 3; n++ {
+		<-ch
+	}
+	cancel()
+	close(unblock)
+	for json := range ch {
+		t.Errorf("Cancelling after the last result must not send an error, found %v", json.Err)
+	}
+}
+
+// blockedReader reads from r once unblock is closed
+type blockedReader struct {
+	unblock chan struct{}
+	r       io.Reader
+}
+
+func (b *blockedReader) Read(p []byte) (int, error) {
+	<-b.unblock
+	return b.r.Read(p)
+}
+
+func TestIterator(t *testing.T) {
+
+	p := getparser("a")
+	count := 0
+	for p.Next() {
+		if p.Value() == nil {
+			t.Fatal("Value must not be nil")
+		}
+		count++
+	}
+	if p.Err() != nil || count != 7 {
+		t.Errorf("Expected 7 results without error, found %d %v", count, p.Err())
+	}
+
+	br := bufio.NewReader(strings.NewReader(`{"list":[{"a":1},{"a":2},{"a":x}]}`))
+	p = NewJSONParser(br, "list")
+	count = 0
+	var err error
+	for json, e := range p.All() {
+		if e != nil {
+			err = e
+			break
+		}
+		if json.GetValue("a") == "" {
+			t.Error("Unexpected result")
+		}
+		count++
+	}
+	if count != 2 || err == nil {
+		t.Errorf("Expected 2 results and an error, found %d %v", count, err)
+	}
+
+	p = getparser("a")
+	for range p.All() {
+		break
+	}
+	if !p.Next() || p.Value().ValueType != Object {
+		t.Error("Iteration must resume after an early exit")
+	}
+}
+
+type testComment struct {
+	Rating  int    `json:"rating"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type testMeta struct {
+	Published time.Time `json:"published"`
+}
+
+type testBook struct {
+	testMeta
+	Title    string            `json:"title"`
+	Price    float64           `json:"price"`
+	Comments []testComment     `json:"comments"`
+	Best     *testComment      `json:"best"`
+	Tags     map[string]string `json:"tags"`
+	Extra    interface{}       `json:"extra"`
+	Ignored  string            `json:"-"`
+	InStock  bool
+}
+
+func TestDecode(t *testing.T) {
+
+	const doc = `{"books":[
+		{"title":"The Iliad","price":12.95,"published":"2001-02-03T04:05:06Z","instock":true,"Ignored":"x",
+		 "comments":[{"rating":4,"comment":"Best"},{"rating":2}],"best":{"rating":5},"tags":{"lang":"en"},"extra":["a",{"b":true}]},
+		{"title":"Anthology","price":"cheap"}
+	]}`
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	p := NewJSONParser(br, "books")
+
+	var books []testBook
+	var errs []error
+	for book, err := range StreamInto[testBook](p) {
+		books = append(books, book)
+		errs = append(errs, err)
+	}
+
+	if len(books) != 2 || errs[0] != nil {
+		t.Fatalf("Unexpected decode results %v %v", books, errs)
+	}
+
+	b := books[0]
+	if b.Title != "The Iliad" || b.Price != 12.95 || !b.InStock || b.Ignored != "" {
+		t.Errorf("Unexpected scalar fields %+v", b)
+	}
+	if !b.Published.Equal(time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)) {
+		t.Errorf("Unexpected time %v", b.Published)
+	}
+	if len(b.Comments) != 2 || b.Comments[0].Comment != "Best" || b.Comments[1].Rating != 2 {
+		t.Errorf("Unexpected comments %+v", b.Comments)
+	}
+	if b.Best == nil || b.Best.Rating != 5 || b.Tags["lang"] != "en" {
+		t.Errorf("Unexpected pointer or map fields %+v", b)
+	}
+	if extra, ok := b.Extra.([]interface{}); !ok || len(extra) != 2 || extra[1].(map[string]interface{})["b"] != true {
+		t.Errorf("Unexpected interface field %#v", b.Extra)
+	}
+
+	var derr *DecodeError
+	if !errors.As(errs[1], &derr) || derr.Path != "price" {
+		t.Errorf("Decode error for price expected, found %v", errs[1])
+	}
+
+	var n int
+	if err := (&JSON{ValueType: Object, ObjectVals: map[string]interface{}{}}).Decode(&n); err == nil {
+		t.Error("Decoding an object into an int must fail")
+	}
+
+	var ints []int
+	for v, err := range StreamInto[int](NewJSONParser(bufio.NewReader(strings.NewReader(`{"n":[1,2,3]}`)), "n")) {
+		if err != nil {
+			t.Fatalf("Numeric loop values must decode into int, found %v", err)
+		}
+		ints = append(ints, v)
+	}
+	if len(ints) != 3 || ints[2] != 3 {
+		t.Errorf("Unexpected ints %v", ints)
+	}
+	for _, err := range StreamInto[string](NewJSONParser(bufio.NewReader(strings.NewReader(`{"n":[1]}`)), "n")) {
+		if err == nil {
+			t.Error("Decoding a number into a string must fail")
+		}
+	}
+
+	r := allResult(NewJSONParser(bufio.NewReader(strings.NewReader(`{"r":{"a":42,"f":2.5,"s":"x"}}`)), "r"))[0]
+	var f float64
+	var s string
+	if err := r.GetNode("a").Decode(&n); err != nil || n != 42 {
+		t.Errorf("Unexpected int %d %v", n, err)
+	}
+	if err := r.GetNode("f").Decode(&f); err != nil || f != 2.5 {
+		t.Errorf("Unexpected float %f %v", f, err)
+	}
+	if err := r.GetNode("s").Decode(&s); err != nil || s != "x" {
+		t.Errorf("Unexpected string %q %v", s, err)
+	}
+	if err := r.GetNode("a").Decode(&s); err == nil {
+		t.Error("Decoding a number node into a string must fail")
+	}
+	var fields struct {
+		A int
+		F float64
+		S string
+	}
+	if err := r.Decode(&fields); err != nil || fields.A != 42 || fields.F != 2.5 || fields.S != "x" {
+		t.Errorf("Unexpected fields %+v %v", fields, err)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+
+	const doc = `{"r":{"id":9007199254740993,"big":18446744073709551615,"huge":1e400,"f":0.1,"e":1e3,"neg":-5}}`
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	p := NewJSONParser(br, "r")
+	res := allResult(p)
+	if len(res) != 1 || res[0].Err != nil {
+		t.Fatalf("Unexpected results %v", res)
+	}
+	r := res[0]
+
+	if v, ok := r.ObjectVals["id"].(json.Number); !ok || v != "9007199254740993" {
+		t.Errorf("Numbers must be kept as json.Number, found %#v", r.ObjectVals["id"])
+	}
+	if i, err := r.GetNode("id").Int64(); err != nil || i != 9007199254740993 {
+		t.Errorf("Int64 lost precision %d %v", i, err)
+	}
+	if r.GetValueInt("id") != 9007199254740993 {
+		t.Errorf("GetValueInt lost precision %d", r.GetValueInt("id"))
+	}
+	if _, err := r.GetNode("big").Int64(); !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("Int64 overflow error expected, found %v", err)
+	}
+	if u, err := r.GetNode("big").Uint64(); err != nil || u != math.MaxUint64 {
+		t.Errorf("Unexpected Uint64 %d %v", u, err)
+	}
+	if _, err := r.GetNode("neg").Uint64(); err == nil {
+		t.Error("Uint64 of a negative number must fail")
+	}
+	if _, err := r.GetNode("huge").Float64(); !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("Float64 overflow error expected, found %v", err)
+	}
+	if f, err := r.GetNode("huge").BigFloat(); err != nil || f.MantExp(nil) != 1329 {
+		t.Errorf("Unexpected BigFloat %v %v", f, err)
+	}
+	if i, err := r.GetNode("e").Int64(); err != nil || i != 1000 {
+		t.Errorf("Integral exponent must convert to Int64 %d %v", i, err)
+	}
+	if _, err := r.GetNode("f").Int64(); err == nil {
+		t.Error("Int64 of a fraction must fail")
+	}
+	if n, err := r.GetNode("f").JSONNumber(); err != nil || n != "0.1" {
+		t.Errorf("Unexpected JSONNumber %s %v", n, err)
+	}
+	if r.GetNode("f").ValueType != Number {
+		t.Error("Number nodes must have the Number value type")
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"r":{"id":9007199254740993,"big":18446744073709551615,"f":0.1,"a":[1,2.5]}}`))
+	p = NewJSONParser(br, "r").Numbers(NumberEager)
+	r = allResult(p)[0]
+	if r.ObjectVals["id"] != int64(9007199254740993) || r.ObjectVals["big"] != uint64(math.MaxUint64) || r.ObjectVals["f"] != 0.1 {
+		t.Errorf("Unexpected eager numbers %#v", r.ObjectVals)
+	}
+	if r.GetValue("a[1]") != "2.5" || r.GetValue("f") != "0.1" {
+		t.Errorf("Unexpected formatting of eager numbers %s %s", r.GetValue("a[1]"), r.GetValue("f"))
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"r":{"huge":1e400,"a":[-1e999]}}`))
+	res = allResult(NewJSONParser(br, "r").Numbers(NumberEager))
+	if len(res) != 1 || res[0].Err != nil || res[0].ObjectVals["huge"] != json.Number("1e400") || res[0].GetValue("a[0]") != "-1e999" {
+		t.Errorf("Eager numbers out of float64 range must stay json.Number, found %v", res)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"r":{"n":5,"a":[1.5]}}`))
+	r = allResult(NewJSONParser(br, "r").Numbers(NumberString))[0]
+	if n, ok := r.ObjectVals["n"].(string); !ok || n != "5" || r.GetValue("a[0]") != "1.5" {
+		t.Errorf("NumberString must keep plain strings, found %#v", r.ObjectVals)
+	}
+}
+
+func TestStrictNumbers(t *testing.T) {
+
+	valid := []string{"0", "-0", "12", "-12.5", "0.5e10", "1E+2", "1e-2", "23.23e-6"}
+	for _, n := range valid {
+		br := bufio.NewReader(strings.NewReader(`{"n":` + n + `,"a":[` + n + `]}`))
+		p := NewJSONParser(br, "n").LoopProps([]string{"a"})
+		for _, json := range allResult(p) {
+			if json.Err != nil || json.StringVal != n {
+				t.Errorf("Number %s must be valid, found %q %v", n, json.StringVal, json.Err)
+			}
+		}
+	}
+
+	invalid := []string{"12abc", "--1", "01", "1.", "1e", "-", "1.e3", "1e+", "0x10"}
+	for _, n := range invalid {
+		br := bufio.NewReader(strings.NewReader(`{"n":` + n + `}`))
+		p := NewJSONParser(br, "n")
+		res := allResult(p)
+		var serr *SyntaxError
+		if len(res) != 1 || !errors.As(res[0].Err, &serr) {
+			t.Errorf("Number %s must be invalid, found %v", n, res)
+		}
+
+		br = bufio.NewReader(strings.NewReader(`{"n":` + n + `}`))
+		p = NewJSONParser(br, "n").LenientNumbers()
+		res = allResult(p)
+		if len(res) != 1 || res[0].Err != nil || res[0].StringVal != n {
+			t.Errorf("Number %s must be accepted in lenient mode, found %v", n, res)
+		}
+	}
+}
+
+func TestNullValues(t *testing.T) {
+
+	br := bufio.NewReader(strings.NewReader(`{"r":{"n":null,"e":"","a":[null,""],"o":{"n":null}}}`))
+	p := NewJSONParser(br, "r")
+	r := allResult(p)[0]
+
+	if n, ok := r.ObjectVals["n"].(*JSON); !ok || !n.IsNull() {
+		t.Errorf("Null must be stored as a Null node, found %#v", r.ObjectVals["n"])
+	}
+	if r.ObjectVals["e"] != "" {
+		t.Errorf("Empty string must be stored as string, found %#v", r.ObjectVals["e"])
+	}
+
+	nodes := r.GetNodes("n")
+	if len(nodes) != 1 || nodes[0].ValueType != Null || !nodes[0].IsEmpty() {
+		t.Errorf("GetNodes must report null, found %v", nodes)
+	}
+	nodes = r.GetNodes("e")
+	if len(nodes) != 1 || nodes[0].ValueType != String {
+		t.Errorf("GetNodes must report the empty string, found %v", nodes)
+	}
+	nodes = r.GetNodes("a")
+	if len(nodes) != 2 || !nodes[0].IsNull() || nodes[1].ValueType != String {
+		t.Errorf("GetNodes must report null array values, found %v", nodes)
+	}
+	if r.GetNode("o.n") != nil || r.GetValue("o.n") != "" || len(r.GetNodes("missing")) != 0 {
+		t.Error("Null and missing values must have no node and an empty value")
+	}
+
+	var v struct {
+		N *string `json:"n"`
+		E *string `json:"e"`
+	}
+	if err := r.Decode(&v); err != nil || v.N != nil || v.E == nil {
+		t.Errorf("Null must decode to nil and the empty string to a value %v", err)
+	}
+}
+
+func TestPreserveOrder(t *testing.T) {
+
+	const doc = `{"r":{"z":1,"a":"x","m":{"y":true,"b":null},"z":2}}`
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	p := NewJSONParser(br, "r").PreserveOrder()
+	r := allResult(p)[0]
+
+	if keys := strings.Join(r.Keys(), ","); keys != "z,a,m" {
+		t.Errorf("Keys must keep the input order, found %s", keys)
+	}
+	if keys := strings.Join(r.GetNode("m").Keys(), ","); keys != "y,b" {
+		t.Errorf("Nested keys must keep the input order, found %s", keys)
+	}
+	if r.Len() != 3 || r.GetValue("z") != "2" {
+		t.Errorf("Unexpected length or value %d %s", r.Len(), r.GetValue("z"))
+	}
+
+	var pairs []string
+	for key, value := range r.Pairs() {
+		pairs = append(pairs, key+"="+value.GetValue("."))
+		if key == "a" {
+			break
+		}
+	}
+	if strings.Join(pairs, ",") != "z=2,a=x" {
+		t.Errorf("Unexpected pairs %v", pairs)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	p = NewJSONParser(br, "r")
+	r = allResult(p)[0]
+	if keys := strings.Join(r.Keys(), ","); keys != "a,m,z" {
+		t.Errorf("Keys must be sorted without PreserveOrder, found %s", keys)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"r":[{"a":1,"b":2}]}`))
+	var sb strings.Builder
+	err := NewJSONParser(br, "r").PreserveOrder().Rewrite(&sb, func(o *JSON) (*JSON, error) {
+		delete(o.ObjectVals, "a")
+		o.ObjectVals["d"] = "4"
+		o.ObjectVals["c"] = "3"
+		return o, nil
+	})
+	if err != nil || sb.String() != `{"r":[{"b":2,"c":"3","d":"4"}]}` {
+		t.Errorf("Keys must follow deleted and added properties, found %s %v", sb.String(), err)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+
+	const doc = "{\"r\":{\"a\":1,\"b\":true,\n \"a\":2,\"a\":{\"c\":3}}}"
+
+	parse := func(policy DuplicatePolicy) *JSON {
+		br := bufio.NewReader(strings.NewReader(doc))
+		return allResult(NewJSONParser(br, "r").DuplicateKeys(policy))[0]
+	}
+
+	if r := parse(DuplicateLastWins); r.Err != nil || r.GetValue("a.c") != "3" {
+		t.Errorf("Last value must win by default %v", r.ObjectVals)
+	}
+	if r := parse(DuplicateFirstWins); r.Err != nil || r.GetValue("a") != "1" {
+		t.Errorf("First value must win %v", r.ObjectVals)
+	}
+
+	r := parse(DuplicateError)
+	var derr *DuplicateKeyError
+	if !errors.As(r.Err, &derr) || derr.Key != "a" || derr.Line != 2 || derr.Column != 2 || derr.Offset != 23 {
+		t.Errorf("Duplicate key error expected, found %+v", r.Err)
+	}
+
+	r = parse(DuplicateCollect)
+	nodes := r.GetNodes("a")
+	if r.Err != nil || len(nodes) != 3 || nodes[0].GetValue(".") != "1" || nodes[1].GetValue(".") != "2" || nodes[2].GetValue("c") != "3" {
+		t.Errorf("All values must be collected %v", nodes)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+
+	const doc = `{"r":{"z":1.50,"s":"q\"\\\n\u0001é","n":null,"b":false,"a":[1,{"x":[]},{}],"id":12345678901234567890}}`
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	r := allResult(NewJSONParser(br, "r").PreserveOrder())[0]
+
+	expected := `{"z":1.50,"s":"q\"\\\n\u0001é","n":null,"b":false,"a":[1,{"x":[]},{}],"id":12345678901234567890}`
+	out, err := r.MarshalJSON()
+	if err != nil || string(out) != expected {
+		t.Errorf("MarshalJSON doesn´t match with expected \n\t Expected: %s \n\t Found: %s %v", expected, out, err)
+	}
+
+	out, err = json.Marshal(map[string]*JSON{"r": r})
+	if err != nil || string(out) != `{"r":`+expected+`}` {
+		t.Errorf("encoding/json must use MarshalJSON, found %s %v", out, err)
+	}
+
+	var indented bytes.Buffer
+	json.Indent(&indented, []byte(expected), ">", "  ")
+	out, err = r.MarshalIndent(">", "  ")
+	if err != nil || string(out) != indented.String() {
+		t.Errorf("MarshalIndent doesn´t match with expected \n\t Expected: %s \n\t Found: %s %v", indented.String(), out, err)
+	}
+
+	var sb strings.Builder
+	n, err := r.WriteTo(&sb)
+	if err != nil || sb.String() != expected || n != int64(len(expected)) {
+		t.Errorf("WriteTo doesn´t match with expected %s %d %v", sb.String(), n, err)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	r = allResult(NewJSONParser(br, "r").Numbers(NumberEager))[0]
+	out, _ = r.MarshalJSON()
+	if string(out) != `{"a":[1,{"x":[]},{}],"b":false,"id":12345678901234567890,"n":null,"s":"q\"\\\n\u0001é","z":1.5}` {
+		t.Errorf("Keys must be sorted without PreserveOrder, found %s", out)
+	}
+
+	if _, err := (&JSON{Err: errors.New("bad"), ValueType: Invalid}).MarshalJSON(); err == nil {
+		t.Error("Invalid values must not be written")
+	}
+}
+
+func TestWriter(t *testing.T) {
+
+	br := bufio.NewReader(strings.NewReader(`{"books":[{"t":"a","n":1.0},{"t":"b","n":[]}]}`))
+	p := NewJSONParser(br, "books").PreserveOrder()
+
+	var sb strings.Builder
+	w := NewJSONWriter(&sb)
+	w.BeginObject()
+	w.Key("count")
+	w.Number("2")
+	w.Key("ok")
+	w.Bool(true)
+	w.Key("none")
+	w.Null()
+	w.Key("name")
+	w.String("x\"y")
+	w.Key("books")
+	w.BeginArray()
+	if err := w.WriteAll(p.Stream()); err != nil {
+		t.Fatal(err)
+	}
+	w.EndArray()
+	w.EndObject()
+	w.BeginArray()
+	w.EndArray()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"count":2,"ok":true,"none":null,"name":"x\"y","books":[{"t":"a","n":1.0},{"t":"b","n":[]}]}` + "\n[]"
+	if sb.String() != expected {
+		t.Errorf("Writer output doesn´t match with expected \n\t Expected: %s \n\t Found: %s", expected, sb.String())
+	}
+
+	sb.Reset()
+	w = NewJSONWriter(&sb).Indent("", "  ")
+	w.BeginObject()
+	w.Key("a")
+	w.BeginArray()
+	w.Value(&JSON{StringVal: "1", ValueType: Number})
+	w.Value(&JSON{ObjectVals: map[string]interface{}{"b": true}, ValueType: Object})
+	w.EndArray()
+	w.EndObject()
+	w.Close()
+	var indented bytes.Buffer
+	json.Indent(&indented, []byte(`{"a":[1,{"b":true}]}`), "", "  ")
+	if sb.String() != indented.String() {
+		t.Errorf("Indented writer output doesn´t match with expected \n\t Expected: %s \n\t Found: %s", indented.String(), sb.String())
+	}
+
+	w = NewJSONWriter(ioutil.Discard)
+	w.BeginObject()
+	if err := w.String("v"); err == nil {
+		t.Error("Value without Key must be an error")
+	}
+	if err := w.EndObject(); err == nil {
+		t.Error("Errors must be sticky")
+	}
+
+	w = NewJSONWriter(ioutil.Discard)
+	w.BeginArray()
+	if err := w.EndObject(); err == nil {
+		t.Error("Mismatched end must be an error")
+	}
+
+	w = NewJSONWriter(ioutil.Discard)
+	w.BeginArray()
+	if err := w.Close(); err == nil {
+		t.Error("Unclosed array must be an error")
+	}
+
+	for _, n := range []json.Number{"1x", "", "01", "1."} {
+		w = NewJSONWriter(ioutil.Discard)
+		w.BeginObject()
+		w.Key("a")
+		if err := w.Number(n); err == nil {
+			t.Errorf("Number %q must be an error", n)
+		}
+		w.EndObject()
+		if err := w.Close(); err == nil {
+			t.Errorf("Number %q must fail Close", n)
+		}
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"r":{"n":12abc,"a":[1x]}}`))
+	r := allResult(NewJSONParser(br, "r").LenientNumbers())[0]
+	if _, err := r.MarshalJSON(); err == nil {
+		t.Error("Numbers accepted by LenientNumbers must not be written")
+	}
+	if _, err := r.GetNode("n").MarshalJSON(); err == nil {
+		t.Error("Number nodes accepted by LenientNumbers must not be written")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+
+	const doc = `{ "meta" : {"n": 2},
+  "books": [ {"id":1, "t":"a"} ,
+    {"id": 2,"t":"b"}, 7 ],
+  "end": true }
+`
+	br := bufio.NewReader(strings.NewReader(doc))
+	var sb strings.Builder
+	err := NewJSONParser(br, "books").Rewrite(&sb, func(book *JSON) (*JSON, error) {
+		if book.ValueType == Number {
+			return nil, nil
+		}
+		return &JSON{StringVal: book.GetValue("t"), ValueType: String}, nil
+	})
+
+	expected := `{ "meta" : {"n": 2},
+  "books": [ "a" ,
+    "b", null ],
+  "end": true }
+`
+	if err != nil || sb.String() != expected {
+		t.Errorf("Rewrite doesn´t match with expected \n\t Expected: %s \n\t Found: %s %v", expected, sb.String(), err)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":1,"b":[1,2]} {"a":3}`))
+	sb.Reset()
+	err = NewJSONParser(br, "a").NDJSON().Rewrite(&sb, func(a *JSON) (*JSON, error) {
+		return &JSON{StringVal: a.StringVal + "0", ValueType: Number}, nil
+	})
+	if err != nil || sb.String() != `{"a":10,"b":[1,2]} {"a":30}` {
+		t.Errorf("Rewrite of scalars doesn´t match with expected, found %s %v", sb.String(), err)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":[{"z":1,"b":{"y":2,"c":3}}]}`))
+	sb.Reset()
+	err = NewJSONParser(br, "a").Rewrite(&sb, func(a *JSON) (*JSON, error) { return a, nil })
+	if err != nil || sb.String() != `{"a":[{"z":1,"b":{"y":2,"c":3}}]}` {
+		t.Errorf("Rewrite must keep the order of properties, found %s %v", sb.String(), err)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":[` + strings.Repeat(`{"t":"`+strings.Repeat("x", 100)+`"},`, 5000) + `1]}`))
+	calls := 0
+	err = NewJSONParser(br, "a").Rewrite(failWriter{}, func(a *JSON) (*JSON, error) {
+		calls++
+		return a, nil
+	})
+	if err == nil || err.Error() != "disk full" || calls > 1000 {
+		t.Errorf("Rewrite must stop at the first error of the writer, found %v after %d values", err, calls)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":[1,2]}`))
+	stop := errors.New("stop")
+	err = NewJSONParser(br, "a").Rewrite(ioutil.Discard, func(*JSON) (*JSON, error) { return nil, stop })
+	if err != stop {
+		t.Errorf("Rewrite must return the error of the callback, found %v", err)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":[1,2}`))
+	err = NewJSONParser(br, "a").Rewrite(ioutil.Discard, func(a *JSON) (*JSON, error) { return a, nil })
+	var serr *SyntaxError
+	if !errors.As(err, &serr) {
+		t.Errorf("Rewrite must return syntax errors, found %v", err)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestJSONPath(t *testing.T) {
+
+	const doc = `{"store":{"book":[
+		{"category":"reference","author":"Nigel Rees","title":"Sayings of the Century","price":8.95},
+		{"category":"fiction","author":"Evelyn Waugh","title":"Sword of Honour","price":12.99},
+		{"category":"fiction","author":"Herman Melville","title":"Moby Dick","isbn":"0-553-21311-3","price":8.99},
+		{"category":"fiction","author":"J. R. R. Tolkien","title":"The Lord of the Rings","isbn":"0-395-19395-8","price":22.99}],
+		"bicycle":{"color":"red","price":399}},
+		"key with.dot":[0,1,2,3,4,5,6,7,8,9],"n":null,"o":{"j":1,"k":[{"a":"b"}]}}`
+
+	root := allResult(NewJSONParser(bufio.NewReader(strings.NewReader(doc)), "").PreserveOrder())[0]
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{`$.store.book[*].author`, `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{`$..author`, `["Nigel Rees","Evelyn Waugh","Herman Melville","J. R. R. Tolkien"]`},
+		{`$.store.*`, `[[{"category":"reference","author":"Nigel Rees","title":"Sayings of the Century","price":8.95},{"category":"fiction","author":"Evelyn Waugh","title":"Sword of Honour","price":12.99},{"category":"fiction","author":"Herman Melville","title":"Moby Dick","isbn":"0-553-21311-3","price":8.99},{"category":"fiction","author":"J. R. R. Tolkien","title":"The Lord of the Rings","isbn":"0-395-19395-8","price":22.99}],{"color":"red","price":399}]`},
+		{`$.store..price`, `[8.95,12.99,8.99,22.99,399]`},
+		{`$..book[2].title`, `["Moby Dick"]`},
+		{`$..book[-1].title`, `["The Lord of the Rings"]`},
+		{`$..book[0,1].title`, `["Sayings of the Century","Sword of Honour"]`},
+		{`$..book[:2].title`, `["Sayings of the Century","Sword of Honour"]`},
+		{`$..book[?@.isbn].title`, `["Moby Dick","The Lord of the Rings"]`},
+		{`$..book[?(@.price < 10)].title`, `["Sayings of the Century","Moby Dick"]`},
+		{`$..book[?@.price<10 && @.category=='fiction'].title`, `["Moby Dick"]`},
+		{`$..book[?!(@.price<10) || @.author == "Nigel Rees"].price`, `[8.95,12.99,22.99]`},
+		{`$.store.book[?@.price > $.store.book[0].price].title`, `["Sword of Honour","Moby Dick","The Lord of the Rings"]`},
+		{`$..book[?match(@.author, 'J.*')].author`, `["J. R. R. Tolkien"]`},
+		{`$..book[?search(@.title, "of")].title`, `["Sayings of the Century","Sword of Honour","The Lord of the Rings"]`},
+		{`$..book[?length(@.title) == 9].title`, `["Moby Dick"]`},
+		{`$.store[?count(@.*) > 2]`, `[[{"category":"reference","author":"Nigel Rees","title":"Sayings of the Century","price":8.95},{"category":"fiction","author":"Evelyn Waugh","title":"Sword of Honour","price":12.99},{"category":"fiction","author":"Herman Melville","title":"Moby Dick","isbn":"0-553-21311-3","price":8.99},{"category":"fiction","author":"J. R. R. Tolkien","title":"The Lord of the Rings","isbn":"0-395-19395-8","price":22.99}]]`},
+		{`$.o.k[?value(@..a) == 'b']`, `[{"a":"b"}]`},
+		{`$['key with.dot'][1:3]`, `[1,2]`},
+		{`$['key with.dot'][1:7:2]`, `[1,3,5]`},
+		{`$['key with.dot'][::-3]`, `[9,6,3,0]`},
+		{`$['key with.dot'][-2:]`, `[8,9]`},
+		{`$["key with.dot"][0:5:0]`, `[]`},
+		{`$.n`, `[null]`},
+		{`$[?@ == null]`, `[null]`},
+		{`$.o[?@ == {"a":"b"}]`, `ERR`},
+		{`$.o.k[?@ == $.o.k[0]]`, `[{"a":"b"}]`},
+		{`$..*`, ``},
+		{`store.bicycle.color`, `["red"]`},
+		{`$.nothing[0]`, `[]`},
+		{`$.store.book[?@.price == 8.950]`, `[{"category":"reference","author":"Nigel Rees","title":"Sayings of the Century","price":8.95}]`},
+		{`$.store['bicycle'].color`, `["red"]`},
+		{`$.store.book[01]`, `ERR`},
+		{`$.store.book[?@.* == 1]`, `ERR`},
+		{`$.store.book[?@.price]]`, `ERR`},
+		{`$.store.book[?length(@.*) == 1]`, `ERR`},
+		{`$.store.book[?'a']`, `ERR`},
+		{`$.store.book[?foo(@)]`, `ERR`},
+		{`$.store.book[?!@.price == 1]`, `ERR`},
+		{`$.store.`, `ERR`},
+	}
+
+	for _, test := range tests {
+
+		nodes, err := root.Query(test.path)
+		if test.expected == "ERR" {
+			if err == nil {
+				t.Errorf("%s must be an invalid path", test.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if test.expected == "" {
+			continue
+		}
+
+		out, _ := json.Marshal(nodes)
+		if string(out) != test.expected {
+			t.Errorf("%s doesn´t match with expected \n\t Expected: %s \n\t Found: %s", test.path, test.expected, out)
+		}
+	}
+
+	p := MustCompileJSONPath(`$[?@ > 10]`)
+	br := bufio.NewReader(strings.NewReader(doc))
+	var prices []string
+	for price, err := range NewJSONParser(br, "price").All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Select(&JSON{ArrayVals: []interface{}{json.Number(price.StringVal)}, ValueType: Array})) > 0 {
+			prices = append(prices, price.StringVal)
+		}
+	}
+	if strings.Join(prices, ",") != "12.99,22.99,399" {
+		t.Errorf("Compiled path over streamed elements found %v", prices)
+	}
+
+	p = MustCompileJSONPath(`$.title`)
+	br = bufio.NewReader(strings.NewReader(doc))
+	var titles []string
+	for _, book := range NewJSONParser(br, "book").Parse() {
+		for _, n := range p.Select(book) {
+			titles = append(titles, n.StringVal)
+		}
+	}
+	if len(titles) != 4 || titles[2] != "Moby Dick" {
+		t.Errorf("Compiled path over streamed elements found %v", titles)
+	}
+}
+
+func TestWhere(t *testing.T) {
+
+	const doc = `{"items":[{"id":1,"status":"active","price":12},{"id":2,"status":"sold","price":20},
+		{"id":3,"status":"active","price":5},{"id":4,"status":"active","price":11.5,"tags":["x"]}]}`
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	resultCount := 0
+	var ids []string
+	for json := range NewJSONParser(br, "items").Where(`@.status == "active" && @.price > 10`).Stream() {
+		if json.Err != nil {
+			t.Fatal(json.Err)
+		}
+		resultCount++
+		ids = append(ids, json.GetValue("id"))
+	}
+	if resultCount != 2 || strings.Join(ids, ",") != "1,4" {
+		t.Errorf("Where found %v", ids)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	res := allResult(NewJSONParser(br, "items").Where(`?@.tags[?@ == 'x']`))
+	if len(res) != 1 || res[0].GetValue("id") != "4" {
+		t.Errorf("Where with nested filter found %v", res)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	res = allResult(NewJSONParser(br, "items").Where(`@.price >`))
+	if len(res) != 1 || res[0].Err == nil {
+		t.Error("Invalid filter must be returned as error")
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":[1, 2 ,3]}`))
+	var sb strings.Builder
+	err := NewJSONParser(br, "a").Where("@ >= 2").Rewrite(&sb, func(n *JSON) (*JSON, error) {
+		return &JSON{StringVal: "0", ValueType: Number}, nil
+	})
+	if err != nil || sb.String() != `{"a":[1, 0 ,0]}` {
+		t.Errorf("Rewrite must keep filtered out values, found %s %v", sb.String(), err)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":[{"z":1, "b":2,"s":"x\u0041"} , {"z":2,"b":3,"s":"y"}]}`))
+	sb.Reset()
+	err = NewJSONParser(br, "a").Where("@.z == 2").SkipProps([]string{"s"}).Rewrite(&sb, func(n *JSON) (*JSON, error) {
+		return &JSON{StringVal: "0", ValueType: Number}, nil
+	})
+	if err != nil || sb.String() != `{"a":[{"z":1, "b":2,"s":"x\u0041"} , 0]}` {
+		t.Errorf("Rewrite must copy filtered out objects as they are, found %s %v", sb.String(), err)
+	}
+}
+
+func TestEscapedPaths(t *testing.T) {
+
+	br := bufio.NewReader(strings.NewReader(`{"r":{"user.name":{"first":"ana"},"tags[0]":"t","a":{"b.c":[{"d":1},{"d":2}]},"q\"":true}}`))
+	r := allResult(NewJSONParser(br, "r"))[0]
+
+	tests := map[string]string{
+		`["user.name"].first`: "ana",
+		`['user.name'].first`: "ana",
+		`user\.name.first`:    "ana",
+		`["tags[0]"]`:         "t",
+		`tags\[0\]`:           "t",
+		`a["b.c"][1].d`:       "2",
+		`a['b.c'][0].d`:       "1",
+		`["q\""]`:             "true",
+	}
+	for path, expected := range tests {
+		if found := r.GetValue(path); found != expected {
+			t.Errorf("%s doesn´t match with expected \n\t Expected: %s \n\t Found: %s", path, expected, found)
+		}
+	}
+
+	if nodes := r.GetNodes(`a["b.c"][*]`); len(nodes) != 2 {
+		t.Errorf("[*] must select every element, found %d", len(nodes))
+	}
+
+	for _, path := range []string{``, `a.`, `a..b`, `a[1`, `a["b.c]`, `[0]`, `a[x]`, `a[0][1]`, `a[0]b`, `a]`, `a\`} {
+		if _, err := r.Lookup(path); err == nil {
+			t.Errorf("%q must be a malformed path", path)
+		}
+		if nodes := r.GetNodes(path); len(nodes) != 0 {
+			t.Errorf("Malformed path %q must select nothing", path)
+		}
+	}
+
+	if nodes, err := r.Lookup(`a["b.c"]`); err != nil || len(nodes) != 2 {
+		t.Errorf("Lookup found %d %v", len(nodes), err)
+	}
+}
+
+func TestPointer(t *testing.T) {
+
+	const doc = `{"store":{"books":[{"title":"a"},{"title":"b","a/b":{"m~n":[true,null]}}]},"":0}`
+
+	root := allResult(NewJSONParser(bufio.NewReader(strings.NewReader(doc)), ""))[0]
+
+	tests := map[string]string{
+		`/store/books/1/title`:       `"b"`,
+		`/store/books/1/a~1b/m~0n`:   `[true,null]`,
+		`/store/books/1/a~1b/m~0n/1`: `null`,
+		`/`:                          `0`,
+		``:                           doc,
+	}
+	for ptr, expected := range tests {
+		node, err := root.Pointer(ptr)
+		if err != nil {
+			t.Errorf("%s: %v", ptr, err)
+			continue
+		}
+		if out, _ := node.MarshalJSON(); string(out) != expected && ptr != "" {
+			t.Errorf("%s doesn´t match with expected \n\t Expected: %s \n\t Found: %s", ptr, expected, out)
+		}
+	}
+
+	for _, ptr := range []string{`store`, `/store/books/2`, `/store/books/01`, `/store/books/-`, `/store/x`, `/store/books/0/title/x`, `/a~2`} {
+		if _, err := root.Pointer(ptr); err == nil {
+			t.Errorf("%s must be an error", ptr)
+		}
+	}
+
+	if found := root.GetValue("/store/books/0/title"); found != "a" {
+		t.Errorf("GetValue must accept JSON Pointers, found %s", found)
+	}
+	if _, err := root.Lookup("/a~"); err == nil {
+		t.Error("Lookup must report malformed JSON Pointers")
+	}
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	var locations []string
+	for _, res := range allResult(NewJSONParser(br, "title").LoopProps([]string{"m~n"}).Locations()) {
+		locations = append(locations, res.Location)
+		node, err := root.Pointer(res.Location)
+		a, _ := node.MarshalJSON()
+		b, _ := res.MarshalJSON()
+		if err != nil || string(a) != string(b) {
+			t.Errorf("Location %s doesn´t point to the result: %v", res.Location, err)
+		}
+	}
+	if strings.Join(locations, ",") != "/store/books/0/title,/store/books/1/title,/store/books/1/a~1b/m~0n/0,/store/books/1/a~1b/m~0n/1" {
+		t.Errorf("Locations don´t match with expected, found %v", locations)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	if res := allResult(NewJSONParser(br, "title")); len(res) == 0 || res[0].Location != "" {
+		t.Error("Location must be empty without Locations")
+	}
+}
+
+func TestSkipRules(t *testing.T) {
+
+	const doc = `{"id":1,"books":[{"id":"b1","comments":[{"id":"c1","text":"x"}],"info":{"metadata":{"m":1},"other":2},"reviews":[1],"meta_a":1,"metb":2}],"reviews":3}`
+
+	parse := func(rules ...string) string {
+		br := bufio.NewReader(strings.NewReader(doc))
+		res := allResult(NewJSONParser(br, "").PreserveOrder().SkipProps(rules))
+		if len(res) != 1 || res[0].Err != nil {
+			t.Fatalf("%v: %v", rules, res)
+		}
+		out, _ := res[0].MarshalJSON()
+		return string(out)
+	}
+
+	tests := []struct {
+		rules    []string
+		expected string
+	}{
+		{[]string{"comments.id"}, `{"id":1,"books":[{"id":"b1","comments":[{"text":"x"}],"info":{"metadata":{"m":1},"other":2},"reviews":[1],"meta_a":1,"metb":2}],"reviews":3}`},
+		{[]string{"*.metadata"}, `{"id":1,"books":[{"id":"b1","comments":[{"id":"c1","text":"x"}],"info":{"other":2},"reviews":[1],"meta_a":1,"metb":2}],"reviews":3}`},
+		{[]string{"$.books[*].reviews"}, `{"id":1,"books":[{"id":"b1","comments":[{"id":"c1","text":"x"}],"info":{"metadata":{"m":1},"other":2},"meta_a":1,"metb":2}],"reviews":3}`},
+		{[]string{"$.reviews", "books.meta*"}, `{"id":1,"books":[{"id":"b1","comments":[{"id":"c1","text":"x"}],"info":{"metadata":{"m":1},"other":2},"reviews":[1],"metb":2}]}`},
+		{[]string{"$.books[1].id", "books.met?"}, `{"id":1,"books":[{"id":"b1","comments":[{"id":"c1","text":"x"}],"info":{"metadata":{"m":1},"other":2},"reviews":[1],"meta_a":1}],"reviews":3}`},
+		{[]string{"$.books.id", "$.books[0].comments[*].text"}, `{"id":1,"books":[{"comments":[{"id":"c1"}],"info":{"metadata":{"m":1},"other":2},"reviews":[1],"meta_a":1,"metb":2}],"reviews":3}`},
+	}
+
+	for _, test := range tests {
+		if found := parse(test.rules...); found != test.expected {
+			t.Errorf("%v doesn´t match with expected \n\t Expected: %s \n\t Found: %s", test.rules, test.expected, found)
+		}
+	}
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	res := allResult(NewJSONParser(br, "books").SkipProps([]string{"comments.id"}))
+	if len(res) != 1 || res[0].GetValue("id") != "b1" || res[0].GetValue("comments[0].id") != "" {
+		t.Errorf("Skip rules must apply to loop values, found %v", res)
+	}
+
+	const flat = `{"r":{"user.name":"x","user":{"name":"y","id":1},"tags*":[1],"tagsA":2,"a":{"b.c":{"d":3,"e":4}}}}`
+	flatTests := map[string]string{
+		`["user.name"]`: `{"user":{"name":"y","id":1},"tags*":[1],"tagsA":2,"a":{"b.c":{"d":3,"e":4}}}`,
+		`user\.name`:    `{"user":{"name":"y","id":1},"tags*":[1],"tagsA":2,"a":{"b.c":{"d":3,"e":4}}}`,
+		`user.name`:     `{"user.name":"x","user":{"id":1},"tags*":[1],"tagsA":2,"a":{"b.c":{"d":3,"e":4}}}`,
+		`["tags*"]`:     `{"user.name":"x","user":{"name":"y","id":1},"tagsA":2,"a":{"b.c":{"d":3,"e":4}}}`,
+		`a["b.c"].d`:    `{"user.name":"x","user":{"name":"y","id":1},"tags*":[1],"tagsA":2,"a":{"b.c":{"e":4}}}`,
+		`$.r.a.b\.c`:    `{"user.name":"x","user":{"name":"y","id":1},"tags*":[1],"tagsA":2,"a":{}}`,
+	}
+	for rule, expected := range flatTests {
+		br = bufio.NewReader(strings.NewReader(flat))
+		res = allResult(NewJSONParser(br, "r").PreserveOrder().SkipProps([]string{rule}))
+		if out, _ := res[0].MarshalJSON(); string(out) != expected {
+			t.Errorf("%s doesn´t match with expected \n\t Expected: %s \n\t Found: %s", rule, expected, out)
+		}
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	res = allResult(NewJSONParser(br, "books").SkipProps([]string{"a..b"}))
+	if len(res) != 1 || res[0].Err == nil {
+		t.Error("Invalid skip rule must be returned as error")
+	}
+}
+
+func TestKeepProps(t *testing.T) {
+
+	const doc = `{"items":[{"id":1,"title":"a","author":{"name":"x","age":30},"tags":["t1",{"k":1,"v":2}],"price":12,"status":"active","big":{"deep":[1,2,3]}},
+		{"id":2,"title":"b","author":{"name":"y","age":40},"price":5,"status":"active","big":{"deep":[4]}}]}`
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	res := allResult(NewJSONParser(br, "items").PreserveOrder().KeepProps([]string{"id", "author.name", "tags.k", `["big"]`}))
+	if len(res) != 2 {
+		t.Fatalf("KeepProps found %d results", len(res))
+	}
+	out, _ := res[0].MarshalJSON()
+	expected := `{"id":1,"author":{"name":"x"},"tags":["t1",{"k":1}],"big":{"deep":[1,2,3]}}`
+	if string(out) != expected {
+		t.Errorf("KeepProps doesn´t match with expected \n\t Expected: %s \n\t Found: %s", expected, out)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	res = allResult(NewJSONParser(br, "items").PreserveOrder().KeepProps([]string{"title"}).Where(`@.price > 10 && @.author.age == 30`))
+	if len(res) != 1 {
+		t.Fatalf("KeepProps with Where found %d results", len(res))
+	}
+	out, _ = res[0].MarshalJSON()
+	expected = `{"title":"a","author":{"age":30},"price":12}`
+	if string(out) != expected {
+		t.Errorf("KeepProps must keep the fields of Where \n\t Expected: %s \n\t Found: %s", expected, out)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	res = allResult(NewJSONParser(br, "items").Where(`count(@.*) > 6`).KeepProps([]string{"id"}))
+	if len(res) != 1 || res[0].GetValue("title") != "a" {
+		t.Errorf("Filters reading whole values must disable KeepProps, found %v", res)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	res = allResult(NewJSONParser(br, "items").KeepProps([]string{"tags[0]"}))
+	if len(res) != 1 || res[0].Err == nil {
+		t.Error("Invalid keep path must be returned as error")
+	}
+}
+
+func TestSkipValues(t *testing.T) {
+
+	const doc = `{"items":[{"id":1,"n":12.5e3,"b":false,"z":null,"tags":["a","b","c"]},{"id":2,"n":-0.5 ,"b":true,"z":null,"tags":[]}],"other":7}`
+
+	br := bufio.NewReader(strings.NewReader(doc))
+	res := allResult(NewJSONParser(br, "items").PreserveOrder().SkipProps([]string{"n", "b", "z", "tags[1]"}))
+	var found []string
+	for _, r := range res {
+		out, _ := r.MarshalJSON()
+		found = append(found, string(out))
+	}
+	expected := `{"id":1,"tags":["a","c"]},{"id":2,"tags":[]}`
+	if strings.Join(found, ",") != expected {
+		t.Errorf("Skipped values don´t match with expected \n\t Expected: %s \n\t Found: %s", expected, strings.Join(found, ","))
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	res = allResult(NewJSONParser(br, "items").SkipProps([]string{"$.items[0]"}))
+	if len(res) != 1 || res[0].GetValue("id") != "2" {
+		t.Errorf("Skip rules must apply to looped elements, found %v", res)
+	}
+
+	br = bufio.NewReader(strings.NewReader(doc))
+	res = allResult(NewJSONParser(br, "other").LoopProps([]string{"id"}).SkipProps([]string{"other"}))
+	if len(res) != 2 || res[0].StringVal != "1" || res[1].StringVal != "2" {
+		t.Errorf("Skipped loop properties must not be sent, found %v", res)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":[1,{"x":2} , 3]}`))
+	var sb strings.Builder
+	err := NewJSONParser(br, "a").SkipProps([]string{"a[1]"}).Rewrite(&sb, func(n *JSON) (*JSON, error) {
+		return &JSON{StringVal: "0", ValueType: Number}, nil
+	})
+	if err != nil || sb.String() != `{"a":[0,{"x":2} , 0]}` {
+		t.Errorf("Rewrite must copy skipped loop values, found %s %v", sb.String(), err)
+	}
+
+	br = bufio.NewReader(strings.NewReader(`{"a":{"n":1 2}}`))
+	res = allResult(NewJSONParser(br, "a").SkipProps([]string{"n"}))
+	if len(res) != 1 || res[0].Err == nil {
+		t.Error("Skipped scalars must be followed by a delimiter")
+	}
+}
+
+func BenchmarkSkipScalars(b *testing.B) {
+
+	var sb strings.Builder
+	sb.WriteString(`{"rows":[`)
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `{"id":%d,"a":%d.25,"b":%de10,"c":true,"d":null,"e":-%d}`, i, i, i, i)
+	}
+	sb.WriteString(`]}`)
+	doc := sb.String()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		p := NewJSONParser(bufio.NewReader(strings.NewReader(doc)), "rows").SkipProps([]string{"a", "b", "c", "d", "e"})
+		for p.Next() {
+			nothing(p.Value())
+		}
+	}
+}
+
+func TestBulkScan(t *testing.T) {
+
+	for n := 0; n < 64; n++ {
+		b := []byte(strings.Repeat("a", n))
+		for i := 0; i <= n; i++ {
+			for _, c := range []byte{'"', '\\', 0x1f, 0} {
+				if i < n {
+					b[i] = c
+				}
+				if found := stringSpan(b); found != i {
+					t.Fatalf("stringSpan of %q found %d, expected %d", b, found, i)
+				}
+				if found := indexAny3(b, 'x', c, '"'); c != 0x1f && found != i {
+					t.Fatalf("indexAny3 of %q found %d, expected %d", b, found, i)
+				}
+				if i < n {
+					b[i] = 'a'
+				}
+			}
+		}
+	}
+	if stringSpan([]byte("ab\xff\x80é\x20cd\"")) != 9 {
+		t.Error("stringSpan must skip bytes above 0x7f")
+	}
+
+	long := strings.Repeat(`x\"\\é\u00e9\ud83d\ude00[{`, 40)
+	doc := `{"skip":{"s":"` + long + `","n":[1,{"a":"]}"}]},` + "\n" +
+		`"items":[{"t":"` + long + `","u":"` + strings.Repeat("y", 5000) + `"},{"t":"\\"}]}`
+
+	expected := allResult(NewJSONParser(bufio.NewReaderSize(strings.NewReader(doc), 65536), "items"))
+	for _, size := range []int{16, 17, 61, 4096} {
+		p := NewJSONParser(bufio.NewReaderSize(strings.NewReader(doc), size), "items")
+		res := allResult(p)
+		if len(res) != 2 || len(expected) != 2 {
+			t.Fatalf("Buffer size %d found %d results", size, len(res))
+		}
+		for i := range res {
+			a, _ := res[i].MarshalJSON()
+			b, _ := expected[i].MarshalJSON()
+			if res[i].Err != nil || string(a) != string(b) {
+				t.Errorf("Buffer size %d doesn´t match with expected \n\t Expected: %s \n\t Found: %s %v", size, b, a, res[i].Err)
+			}
+		}
+		if p.TotalReadSize != uint64(len(doc)) {
+			t.Errorf("Buffer size %d read %d bytes, expected %d", size, p.TotalReadSize, len(doc))
+		}
+		if res[0].GetValue("t") != strings.Repeat("x\"\\éé😀[{", 40) {
+			t.Errorf("Buffer size %d decoded %q", size, res[0].GetValue("t"))
+		}
+	}
+
+	bad := `{"skip":{"s":"` + long + `"` + "\n\n" + `,"x":[1`
+	res := allResult(NewJSONPathParser(bufio.NewReaderSize(strings.NewReader(bad), 16), "$.items"))
+	var serr *SyntaxError
+	if len(res) != 1 || !errors.As(res[0].Err, &serr) || !serr.EOF || serr.Offset != uint64(len(bad)) || serr.Line != 3 || serr.Column != 8 {
+		t.Errorf("Unterminated object must be reported at the end of input, found %v", res[0].Err)
+	}
+
+	bad = `{"items":["abc` + strings.Repeat("d", 100) + "\x01\"]}"
+	res = allResult(NewJSONParser(bufio.NewReaderSize(strings.NewReader(bad), 16), "items"))
+	if len(res) != 1 || !errors.As(res[0].Err, &serr) || serr.Offset != 114 || serr.Byte != 1 {
+		t.Errorf("Control characters must be reported where they are, found %v", res[0].Err)
+	}
+}
+
+func BenchmarkIndented(b *testing.B) {
+
+	var rows []map[string]interface{}
+	for i := 0; i < 500; i++ {
+		rows = append(rows, map[string]interface{}{"id": i, "price": float64(i) * 1.25, "tags": []int{i, i * 2, i * 3}, "ok": i%2 == 0, "name": "item"})
+	}
+	doc, _ := json.MarshalIndent(map[string]interface{}{"rows": rows}, "", "        ")
+
+	b.SetBytes(int64(len(doc)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		p := NewJSONParser(bufio.NewReader(bytes.NewReader(doc)), "rows")
+		for p.Next() {
+			nothing(p.Value())
+		}
+	}
+}
+
+func BenchmarkLongStrings(b *testing.B) {
+
+	var sb strings.Builder
+	sb.WriteString(`{"rows":[`)
+	for i := 0; i < 100; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `{"id":%d,"text":"%s","skip":{"body":"%s","list":[1,2,3]}}`, i, strings.Repeat("lorem ipsum ", 100), strings.Repeat("dolor sit amet ", 100))
+	}
+	sb.WriteString(`]}`)
+	doc := sb.String()
+
+	b.SetBytes(int64(len(doc)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		p := NewJSONParser(bufio.NewReader(strings.NewReader(doc)), "rows").SkipProps([]string{"skip"})
+		for p.Next() {
+			nothing(p.Value())
+		}
+	}
+}
+
+func TestInputs(t *testing.T) {
+
+	data, err := ioutil.ReadFile("sample.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := allResult(NewJSONParser(bufio.NewReader(bytes.NewReader(data)), "books"))
+	parsers := map[string]*JsonParser{
+		"bytes":  NewBytesParser(data, "books"),
+		"reader": NewReaderParser(bytes.NewReader(data), "books", 0),
+		"small":  NewReaderParser(bytes.NewReader(data), "books", 16),
+		"bufio":  NewReaderParser(bufio.NewReader(bytes.NewReader(data)), "books", 16),
+	}
+	for name, p := range parsers {
+		res := allResult(p)
+		if len(res) != len(want) {
+			t.Fatalf("%s: expected %d results, found %d", name, len(want), len(res))
+		}
+		for i := range res {
+			if res[i].Err != nil || !reflect.DeepEqual(res[i], want[i]) {
+				t.Errorf("%s: result %d differs: %v", name, i, res[i].Err)
+			}
+		}
+	}
+
+	doc := []byte(`{"items":[{"plain":"abc","esc":"a\"b\\cé😀","long":"` + strings.Repeat("x", 200) + `\n"},["abc","d\te"],"key"]}`)
+	res := allResult(NewBytesParser(doc, "items"))
+	if len(res) != 3 {
+		t.Fatalf("Expected 3 results, found %d", len(res))
+	}
+	if res[0].GetValue("plain") != "abc" || res[0].GetValue("esc") != "a\"b\\cé\U0001F600" || res[0].GetValue("long") != strings.Repeat("x", 200)+"\n" {
+		t.Errorf("Strings decoded wrongly: %q %q", res[0].GetValue("plain"), res[0].GetValue("esc"))
+	}
+	if res[1].ArrayVals[0] != "abc" || res[1].ArrayVals[1] != "d\te" || res[2].StringVal != "key" {
+		t.Errorf("Strings decoded wrongly: %v %q", res[1].ArrayVals, res[2].StringVal)
+	}
+
+	inDoc := func(s string) bool {
+		p := uintptr(unsafe.Pointer(unsafe.StringData(s)))
+		start := uintptr(unsafe.Pointer(&doc[0]))
+		return p >= start && p < start+uintptr(len(doc))
+	}
+	if !inDoc(res[0].GetValue("plain")) || !inDoc(res[1].ArrayVals[0].(string)) || !inDoc(res[2].StringVal) {
+		t.Errorf("Strings without escapes must reference the input")
+	}
+	if inDoc(res[0].GetValue("esc")) || inDoc(res[1].ArrayVals[1].(string)) {
+		t.Errorf("Strings with escapes must be copied")
+	}
+
+	doc = []byte(`{"items":[{"name":"ana"}]}`)
+	res = allResult(NewBytesParser(doc, "items"))
+	copy(doc[12:16], "XXXX")
+	if len(res) != 1 || res[0].ObjectVals["name"] == nil || res[0].Keys()[0] != "name" {
+		t.Errorf("Property names must not reference the input, found %v", res[0].Keys())
+	}
+
+	res = allResult(NewBytesParser([]byte(`{"items":[{"abc":"de`), "items"))
+	if len(res) != 1 || res[0].Err == nil {
+		t.Errorf("Unterminated string must fail")
+	}
+}
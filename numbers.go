@@ -0,0 +1,279 @@
+package jsparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// NumberMode controls how numbers inside objects and arrays are stored
+type NumberMode int8
+
+const (
+	// NumberText keeps numbers as json.Number, the exact text of the input
+	NumberText NumberMode = iota
+	// NumberEager converts numbers to int64, uint64 when they don't fit, or
+	// float64. Numbers beyond the float64 range stay json.Number.
+	NumberEager
+	// NumberString keeps numbers as plain strings like earlier versions. They
+	// can't be told from strings, so they are written back as strings.
+	NumberString
+)
+
+// Numbers sets how numbers inside objects and arrays are stored, NumberText by default
+func (j *JsonParser) Numbers(mode NumberMode) *JsonParser {
+
+	j.numberMode = mode
+	return j
+
+}
+
+// LenientNumbers accepts any run of bytes up to a delimiter as a number, as
+// earlier versions did, instead of validating the json number grammar
+func (j *JsonParser) LenientNumbers() *JsonParser {
+
+	j.lenientNumbers = true
+	return j
+
+}
+
+// states of the number grammar -?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?
+const (
+	numInvalid   = iota
+	numBegin     // nothing read
+	numMinus     // after the minus sign
+	numZero      // after a leading zero
+	numInt       // in the integer part
+	numDot       // after the decimal point
+	numFrac      // in the fraction
+	numExp       // after e or E
+	numExpSign   // after the sign of the exponent
+	numExpDigits // in the exponent
+)
+
+func numberStep(state int, c byte) int {
+
+	digit := c >= '0' && c <= '9'
+
+	switch state {
+	case numBegin:
+		if c == '-' {
+			return numMinus
+		}
+		fallthrough
+	case numMinus:
+		if c == '0' {
+			return numZero
+		}
+		if digit {
+			return numInt
+		}
+	case numInt:
+		if digit {
+			return numInt
+		}
+		fallthrough
+	case numZero:
+		if c == '.' {
+			return numDot
+		}
+		if c == 'e' || c == 'E' {
+			return numExp
+		}
+	case numDot, numFrac:
+		if digit {
+			return numFrac
+		}
+		if state == numFrac && (c == 'e' || c == 'E') {
+			return numExp
+		}
+	case numExp:
+		if c == '+' || c == '-' {
+			return numExpSign
+		}
+		fallthrough
+	case numExpSign, numExpDigits:
+		if digit {
+			return numExpDigits
+		}
+	}
+
+	return numInvalid
+
+}
+
+func numberComplete(state int) bool {
+	return state == numZero || state == numInt || state == numFrac || state == numExpDigits
+}
+
+func numberExpected(state int) string {
+
+	switch state {
+	case numZero:
+		return "'.', 'e' or end of number"
+	case numInt:
+		return "digit, '.', 'e' or end of number"
+	case numFrac:
+		return "digit, 'e' or end of number"
+	case numExp:
+		return "digit, '+' or '-'"
+	case numExpDigits:
+		return "digit or end of number"
+	}
+	return "digit"
+
+}
+
+// validNumber reports whether s follows the json number grammar
+func validNumber(s string) bool {
+
+	state := numBegin
+	for i := 0; i < len(s) && state != numInvalid; i++ {
+		state = numberStep(state, s[i])
+	}
+	return numberComplete(state)
+
+}
+
+// numberValue returns the number in the scratch buffer as it is stored in ObjectVals and ArrayVals
+func (j *JsonParser) numberValue() interface{} {
+
+	s := j.scratch.string()
+
+	switch j.numberMode {
+	case NumberString:
+		return s
+	case NumberEager:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return u
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return json.Number(s)
+
+}
+
+// numberText returns the text of a number node
+func (element *JSON) numberText() (string, error) {
+
+	if element == nil || element.ValueType != Number {
+		return "", fmt.Errorf("Not a number")
+	}
+	return element.StringVal, nil
+
+}
+
+// Int64 returns the number as int64. Numbers out of range or with a fraction are an error.
+func (element *JSON) Int64() (int64, error) {
+
+	s, err := element.numberText()
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrSyntax {
+		// may still be an integer written with a fraction or exponent, like 1.0 or 1e3
+		f, _, perr := big.ParseFloat(s, 10, 0, big.ToNearestEven)
+		if perr != nil || !f.IsInt() {
+			return 0, ne
+		}
+		i, acc := f.Int64()
+		if acc != big.Exact {
+			return i, &strconv.NumError{Func: "Int64", Num: s, Err: strconv.ErrRange}
+		}
+		return i, nil
+	}
+	return i, err
+
+}
+
+// Uint64 returns the number as uint64. Negative numbers, numbers out of range
+// or with a fraction are an error.
+func (element *JSON) Uint64() (uint64, error) {
+
+	s, err := element.numberText()
+	if err != nil {
+		return 0, err
+	}
+
+	u, err := strconv.ParseUint(s, 10, 64)
+	if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrSyntax {
+		f, _, perr := big.ParseFloat(s, 10, 0, big.ToNearestEven)
+		if perr != nil || !f.IsInt() {
+			return 0, ne
+		}
+		u, acc := f.Uint64()
+		if acc != big.Exact {
+			return u, &strconv.NumError{Func: "Uint64", Num: s, Err: strconv.ErrRange}
+		}
+		return u, nil
+	}
+	return u, err
+
+}
+
+// Float64 returns the number as float64. Numbers beyond the float64 range are an error.
+func (element *JSON) Float64() (float64, error) {
+
+	s, err := element.numberText()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(s, 64)
+
+}
+
+// BigFloat returns the number with enough precision to hold every digit
+func (element *JSON) BigFloat() (*big.Float, error) {
+
+	s, err := element.numberText()
+	if err != nil {
+		return nil, err
+	}
+
+	prec := uint(len(s)) * 4 // bits for every decimal digit
+	if prec < 64 {
+		prec = 64
+	}
+	f, _, err := big.ParseFloat(s, 10, prec, big.ToNearestEven)
+	return f, err
+
+}
+
+// JSONNumber returns the exact text of the number
+func (element *JSON) JSONNumber() (json.Number, error) {
+
+	s, err := element.numberText()
+	return json.Number(s), err
+
+}
+
+// formatNumber formats the numbers stored in ObjectVals and ArrayVals
+func formatNumber(i interface{}) (string, bool) {
+
+	switch n := i.(type) {
+	case json.Number:
+		return string(n), true
+	case int64:
+		return strconv.FormatInt(n, 10), true
+	case uint64:
+		return strconv.FormatUint(n, 10), true
+	case int:
+		return strconv.Itoa(n), true
+	case float64:
+		if math.IsInf(n, 0) || math.IsNaN(n) {
+			return "", false
+		}
+		return strconv.FormatFloat(n, 'g', -1, 64), true
+	}
+	return "", false
+
+}
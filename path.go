@@ -0,0 +1,361 @@
+package jsparser
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// scanner states while looking for the loop values
+const (
+	scanValue      = iota // expecting a value
+	scanValueOrEnd        // after '[' expecting a value or ']'
+	scanKey               // after ',' inside an object expecting a property name
+	scanKeyOrEnd          // after '{' expecting a property name or '}'
+	scanCommaOrEnd        // after a value inside an object or array
+	scanDone              // the root value is complete
+)
+
+// pathFrame is an object or array the scanner is currently inside of
+type pathFrame struct {
+	key     []byte // current property name of an object
+	index   int    // current element index of an array
+	isArray bool
+	loop    *selector // set when the elements of this array are looped over
+}
+
+// pathSegment is one step of an anchored loop path
+type pathSegment struct {
+	key     string
+	index   int // element index, anyIndex matches every element
+	isArray bool
+	literal bool // key was quoted or escaped, it has no globs
+}
+
+const anyIndex = -1
+
+// selector describes where the looped values are found in the document
+type selector struct {
+	name     string        // loop property or path as given by the caller
+	prop     []byte        // property name matched at any depth
+	segments []pathSegment // path from the document root, used when anchored
+	anchored bool
+}
+
+// newSelector matches a property name at any depth. An empty name or $ selects the root value.
+func newSelector(loopProp string) *selector {
+
+	if loopProp == "" || loopProp == "$" {
+		return &selector{name: loopProp, anchored: true}
+	}
+	return &selector{name: loopProp, prop: []byte(loopProp)}
+
+}
+
+// parseLoopPath parses paths like $.catalog.books, data.results or $.items[*].tags
+func parseLoopPath(path string) (*selector, error) {
+
+	sel := &selector{name: path, anchored: true}
+
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return sel, nil
+	}
+
+	for _, part := range strings.Split(path, ".") {
+
+		name := part
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			name = part[:i]
+			part = part[i:]
+		} else {
+			part = ""
+		}
+
+		if name != "" {
+			sel.segments = append(sel.segments, pathSegment{key: name})
+		} else if part == "" {
+			return nil, fmt.Errorf("Invalid loop path %q", path)
+		}
+
+		for part != "" {
+			end := strings.IndexByte(part, ']')
+			if part[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("Invalid loop path %q", path)
+			}
+			index := anyIndex
+			if part[1:end] != "*" {
+				i, err := strconv.Atoi(part[1:end])
+				if err != nil || i < 0 {
+					return nil, fmt.Errorf("Invalid index in loop path %q", path)
+				}
+				index = i
+			}
+			sel.segments = append(sel.segments, pathSegment{index: index, isArray: true})
+			part = part[end+1:]
+		}
+	}
+
+	return sel, nil
+
+}
+
+// match reports whether the value at the current position is a loop value
+func (s *selector) match(stack []pathFrame) bool {
+
+	if !s.anchored {
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		return !top.isArray && bytes.Equal(s.prop, top.key)
+	}
+
+	return len(s.segments) == len(stack) && s.matchPrefix(stack)
+
+}
+
+// matchBelow reports whether a loop value can be inside the value at the current position
+func (s *selector) matchBelow(stack []pathFrame) bool {
+
+	if !s.anchored {
+		return true
+	}
+
+	return len(s.segments) > len(stack) && s.matchPrefix(stack)
+
+}
+
+func (s *selector) matchPrefix(stack []pathFrame) bool {
+
+	for i, frame := range stack {
+		seg := s.segments[i]
+		if seg.isArray != frame.isArray {
+			return false
+		}
+		if seg.isArray {
+			if seg.index != anyIndex && seg.index != frame.index {
+				return false
+			}
+		} else if seg.key != string(frame.key) {
+			return false
+		}
+	}
+	return true
+
+}
+
+// nodeStep is one property of a GetNodes path with an optional array index
+type nodeStep struct {
+	key   string
+	index int64 // math.MaxInt64 selects every element
+}
+
+// parseNodePath splits paths like a.b[0].c, a["user.name"].b or a.user\.name.b
+func parseNodePath(xpath string) ([]nodeStep, error) {
+
+	segs, err := splitPath(xpath, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []nodeStep
+	for _, seg := range segs {
+		if !seg.isArray {
+			steps = append(steps, nodeStep{key: seg.key, index: math.MaxInt64})
+		} else if seg.index != anyIndex {
+			steps[len(steps)-1].index = int64(seg.index)
+		}
+	}
+	return steps, nil
+
+}
+
+// splitPath splits paths like a.b[0].c, a["user.name"][*] or a.user\.name
+// into segments. Quoted and escaped names are literal. With single set an
+// index must follow a property name, one at most, as GetNodes reads them.
+func splitPath(path string, single bool) ([]pathSegment, error) {
+
+	var segs []pathSegment
+	i := 0
+
+	malformed := func(msg string) error {
+		return fmt.Errorf("Invalid path %q at offset %d: %s", path, i, msg)
+	}
+
+	for {
+
+		var key []byte
+		literal := false
+		for i < len(path) && path[i] != '.' && path[i] != '[' {
+			if path[i] == ']' {
+				return nil, malformed("unexpected ']'")
+			}
+			if path[i] == '\\' {
+				if i++; i == len(path) {
+					return nil, malformed("escape at end of path")
+				}
+				literal = true
+			}
+			key = append(key, path[i])
+			i++
+		}
+
+		named := len(key) > 0
+		indexed := false
+		empty := !named
+		if named {
+			segs = append(segs, pathSegment{key: string(key), literal: literal})
+		}
+
+		for i < len(path) && path[i] == '[' {
+
+			i++
+			if i < len(path) && (path[i] == '"' || path[i] == '\'') {
+				p := &jpParser{expr: path, pos: i}
+				name, err := p.string()
+				if err != nil {
+					return nil, malformed("invalid quoted property name")
+				}
+				i = p.pos
+				segs = append(segs, pathSegment{key: name, literal: true})
+				named, indexed = true, false
+			} else {
+				end := strings.IndexByte(path[i:], ']')
+				if end < 0 {
+					return nil, malformed("missing ']'")
+				}
+				if single && !named {
+					return nil, malformed("index without a property name")
+				}
+				if single && indexed {
+					return nil, malformed("only one index per property")
+				}
+				index := anyIndex
+				if idx := path[i : i+end]; idx != "*" {
+					n, err := strconv.Atoi(idx)
+					if err != nil || n < 0 {
+						return nil, malformed("invalid index " + strconv.Quote(idx))
+					}
+					index = n
+				}
+				segs = append(segs, pathSegment{index: index, isArray: true})
+				indexed = true
+				i += end
+			}
+			empty = false
+
+			if i >= len(path) || path[i] != ']' {
+				return nil, malformed("missing ']'")
+			}
+			i++
+		}
+
+		if empty {
+			return nil, malformed("empty property name")
+		}
+		if i == len(path) {
+			return segs, nil
+		}
+		if path[i] != '.' {
+			return nil, malformed("expected '.' or '['")
+		}
+		i++
+	}
+
+}
+
+// skipRule is a SkipProps entry matched against the nesting path of a property
+type skipRule struct {
+	segments []pathSegment // keys not literal may contain * and ? globs
+	anchored bool          // matched from the document root, else as a suffix
+	hasIndex bool          // array levels are matched too, else they are ignored
+}
+
+// parseSkipRule parses rules like comments.id, *.metadata, $.books[*].reviews,
+// a["user.name"] or tags[0], the last one skipping an array element
+func parseSkipRule(rule string) (*skipRule, error) {
+
+	path, anchored := strings.CutPrefix(rule, "$")
+	if anchored && !strings.HasPrefix(path, "[") {
+		path = strings.TrimPrefix(path, ".")
+	}
+
+	segs, err := splitPath(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid skip rule %q: %w", rule, err)
+	}
+
+	r := &skipRule{segments: segs, anchored: anchored}
+	for _, seg := range r.segments {
+		r.hasIndex = r.hasIndex || seg.isArray
+	}
+	return r, nil
+
+}
+
+// match reports whether the property or element at the end of path is skipped
+func (r *skipRule) match(path []pathFrame) bool {
+
+	k := len(r.segments) - 1
+	if len(path) == 0 || path[len(path)-1].isArray != r.segments[k].isArray {
+		return false
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+
+		frame := &path[i]
+		if frame.isArray && !r.hasIndex {
+			continue
+		}
+		if k < 0 {
+			return !r.anchored
+		}
+
+		seg := &r.segments[k]
+		if seg.isArray != frame.isArray {
+			return false
+		}
+		if seg.isArray {
+			if seg.index != anyIndex && seg.index != frame.index {
+				return false
+			}
+		} else if seg.literal && seg.key != string(frame.key) || !seg.literal && !globMatch(seg.key, frame.key) {
+			return false
+		}
+		k--
+	}
+
+	return k < 0
+
+}
+
+// globMatch matches name against a pattern where * is any run of bytes and ? any byte
+func globMatch(pattern string, name []byte) bool {
+
+	p, n := 0, 0
+	star, next := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, n
+			p++
+		case star >= 0: // let the last * take one more byte
+			next++
+			p, n = star+1, next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+
+}
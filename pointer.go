@@ -0,0 +1,134 @@
+package jsparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pointer returns the value at an RFC 6901 JSON Pointer such as /books/0/title.
+// The empty pointer is the element itself.
+func (element *JSON) Pointer(ptr string) (*JSON, error) {
+
+	tokens, err := parsePointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{} = element
+	for i, token := range tokens {
+
+		node, ok := v.(*JSON)
+		if !ok || node == nil {
+			return nil, fmt.Errorf("JSON pointer %q: %s is not an object or array", ptr, formatPointer(tokens[:i]))
+		}
+
+		switch node.ValueType {
+		case Object:
+			if v, ok = node.ObjectVals[token]; !ok {
+				return nil, fmt.Errorf("JSON pointer %q: %s not found", ptr, formatPointer(tokens[:i+1]))
+			}
+		case Array:
+			index, err := pointerIndex(token)
+			if err != nil || index >= len(node.ArrayVals) {
+				return nil, fmt.Errorf("JSON pointer %q: %s not found", ptr, formatPointer(tokens[:i+1]))
+			}
+			v = node.ArrayVals[index]
+		default:
+			return nil, fmt.Errorf("JSON pointer %q: %s is not an object or array", ptr, formatPointer(tokens[:i]))
+		}
+	}
+
+	return toNode(v), nil
+
+}
+
+// parsePointer splits a pointer into its unescaped reference tokens
+func parsePointer(ptr string) ([]string, error) {
+
+	if ptr == "" {
+		return nil, nil
+	}
+	if ptr[0] != '/' {
+		return nil, fmt.Errorf("Invalid JSON pointer %q: must start with '/'", ptr)
+	}
+
+	tokens := strings.Split(ptr[1:], "/")
+	for i, token := range tokens {
+		if !strings.Contains(token, "~") {
+			continue
+		}
+		var sb strings.Builder
+		for k := 0; k < len(token); k++ {
+			if token[k] != '~' {
+				sb.WriteByte(token[k])
+				continue
+			}
+			if k++; k == len(token) || (token[k] != '0' && token[k] != '1') {
+				return nil, fmt.Errorf("Invalid JSON pointer %q: '~' must be followed by '0' or '1'", ptr)
+			}
+			if token[k] == '0' {
+				sb.WriteByte('~')
+			} else {
+				sb.WriteByte('/')
+			}
+		}
+		tokens[i] = sb.String()
+	}
+	return tokens, nil
+
+}
+
+// pointerIndex parses an array index, digits without leading zeros
+func pointerIndex(token string) (int, error) {
+
+	if token == "" || (len(token) > 1 && token[0] == '0') || token[0] == '+' || token[0] == '-' {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.Atoi(token)
+
+}
+
+func formatPointer(tokens []string) string {
+
+	var b []byte
+	for _, token := range tokens {
+		b = appendPointerToken(b, token)
+	}
+	return string(b)
+
+}
+
+// appendPointerToken appends /token escaping ~ and /
+func appendPointerToken(b []byte, token string) []byte {
+
+	b = append(b, '/')
+	for i := 0; i < len(token); i++ {
+		switch token[i] {
+		case '~':
+			b = append(b, '~', '0')
+		case '/':
+			b = append(b, '~', '1')
+		default:
+			b = append(b, token[i])
+		}
+	}
+	return b
+
+}
+
+// location returns the pointer of the value at the current position
+func (j *JsonParser) location() string {
+
+	var b []byte
+	for _, frame := range j.stack {
+		if frame.isArray {
+			b = append(b, '/')
+			b = strconv.AppendInt(b, int64(frame.index), 10)
+		} else {
+			b = appendPointerToken(b, string(frame.key))
+		}
+	}
+	return string(b)
+
+}
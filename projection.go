@@ -0,0 +1,120 @@
+package jsparser
+
+import (
+	"fmt"
+	"math"
+)
+
+// keepNode is a level of the KeepProps paths. A nil node keeps the whole value.
+type keepNode struct {
+	children map[string]*keepNode
+}
+
+// add keeps the value at the path below the node
+func (k *keepNode) add(keys []string) {
+
+	for i, key := range keys {
+		next, ok := k.children[key]
+		if ok && next == nil { // already kept whole
+			return
+		}
+		if i == len(keys)-1 {
+			k.children[key] = nil
+			return
+		}
+		if next == nil {
+			next = &keepNode{children: map[string]*keepNode{}}
+			k.children[key] = next
+		}
+		k = next
+	}
+
+}
+
+// KeepProps leaves every property out of the loop values but the listed
+// ones. Nested paths like author.name keep a single property of an object,
+// arrays on the way are kept with all their elements. Properties used by
+// Where are kept too.
+func (j *JsonParser) KeepProps(keepProps []string) *JsonParser {
+
+	if j.keepRoot == nil {
+		j.keepRoot = &keepNode{children: map[string]*keepNode{}}
+	}
+
+	for _, p := range keepProps {
+		steps, err := parseNodePath(p)
+		if err == nil {
+			keys := make([]string, len(steps))
+			for i, step := range steps {
+				if step.index != math.MaxInt64 {
+					err = fmt.Errorf("Invalid keep path %q, indexes are not supported", p)
+				}
+				keys[i] = step.key
+			}
+			if err == nil {
+				j.keepRoot.add(keys)
+				continue
+			}
+		}
+		if j.err == nil {
+			j.err = err
+		}
+	}
+
+	j.keepFilterFields()
+	return j
+
+}
+
+// keepFilterFields keeps the properties the Where filter reads
+func (j *JsonParser) keepFilterFields() {
+
+	if j.keepRoot == nil || j.where == nil {
+		return
+	}
+
+	jpQueries(j.where, func(q *jpQuery) {
+		var keys []string
+		for _, seg := range q.segments {
+			if seg.descendant || len(seg.selectors) != 1 || seg.selectors[0].kind != jpName {
+				break
+			}
+			keys = append(keys, seg.selectors[0].name)
+		}
+		if len(keys) == 0 { // the filter reads the whole value
+			j.keepAll = true
+			return
+		}
+		j.keepRoot.add(keys)
+	})
+
+}
+
+// jpQueries calls fn for every query of a filter expression
+func jpQueries(e interface{}, fn func(*jpQuery)) {
+
+	switch e := e.(type) {
+	case *jpOr:
+		jpQueries(e.left, fn)
+		jpQueries(e.right, fn)
+	case *jpAnd:
+		jpQueries(e.left, fn)
+		jpQueries(e.right, fn)
+	case *jpNot:
+		jpQueries(e.expr, fn)
+	case *jpExists:
+		fn(e.query)
+	case *jpFuncTest:
+		jpQueries(e.fn, fn)
+	case *jpCompare:
+		jpQueries(e.left, fn)
+		jpQueries(e.right, fn)
+	case *jpFunction:
+		for _, arg := range e.args {
+			jpQueries(arg, fn)
+		}
+	case *jpQuery:
+		fn(e)
+	}
+
+}
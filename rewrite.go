@@ -0,0 +1,143 @@
+package jsparser
+
+import (
+	"bufio"
+	"io"
+)
+
+// Rewrite copies the input to w byte for byte, replacing every loop value
+// with the value returned by fn. A nil value is written as null. Loop values
+// not matching Where are copied unchanged. Parsing stops at the first
+// error of the input, of fn or of w. Properties keep their input order, as
+// with PreserveOrder.
+func (j *JsonParser) Rewrite(w io.Writer, fn func(*JSON) (*JSON, error)) error {
+
+	j.preserveOrder = true
+	ew := &errWriter{w: w}
+	bw := bufio.NewWriterSize(ew, 65536)
+	j.tee = &tee{w: bw}
+	enc := &encoder{w: bw}
+
+	for res := j.next(); res != nil; res = j.next() {
+
+		if res.Err != nil {
+			return res.Err
+		}
+
+		out, err := fn(res)
+		if err != nil {
+			return err
+		}
+
+		if err := enc.node(out, 0); err != nil {
+			return err
+		}
+		j.tee.writeTail()
+
+		if ew.err != nil { // bufio keeps the error of w until Flush
+			return ew.err
+		}
+	}
+
+	j.tee.flush()
+	return bw.Flush()
+
+}
+
+// errWriter remembers the first error of w
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+
+	n, err := e.w.Write(p)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return n, err
+
+}
+
+// tee writes the bytes read to the output of Rewrite. The last byte is held
+// back as it may still be unread.
+type tee struct {
+	w       *bufio.Writer
+	pending byte
+	held    bool
+	off     bool   // a loop value is being read, its bytes are replaced
+	raw     []byte // bytes of the loop value and the whitespace after it
+}
+
+func (t *tee) write(b byte) {
+
+	if t.off {
+		t.raw = append(t.raw, b)
+		return
+	}
+	t.flush()
+	t.pending = b
+	t.held = true
+
+}
+
+// writeAll writes bytes consumed at once, holding back the last one like write
+func (t *tee) writeAll(buf []byte) {
+
+	if t.off {
+		t.raw = append(t.raw, buf...)
+		return
+	}
+	t.flush()
+	t.w.Write(buf[:len(buf)-1])
+	t.pending = buf[len(buf)-1]
+	t.held = true
+
+}
+
+// writeTail writes the whitespace read after a loop value, once it is replaced
+func (t *tee) writeTail() {
+
+	n := len(t.raw)
+	for n > 0 && isSpace(t.raw[n-1]) {
+		n--
+	}
+	t.w.Write(t.raw[n:])
+	t.raw = t.raw[:0]
+
+}
+
+// keep writes a loop value which is not replaced as it was read
+func (t *tee) keep() {
+
+	t.w.Write(t.raw)
+	t.raw = t.raw[:0]
+
+}
+
+func (t *tee) flush() {
+
+	if t.held {
+		t.w.WriteByte(t.pending)
+		t.held = false
+	}
+
+}
+
+// drop forgets the byte held back, after it is unread or belongs to a loop value
+func (t *tee) drop() {
+
+	if t.off {
+		if n := len(t.raw); n > 0 {
+			t.raw = t.raw[:n-1]
+		}
+		return
+	}
+	t.held = false
+
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t' || c == '\r'
+}
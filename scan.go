@@ -0,0 +1,216 @@
+package jsparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"unsafe"
+)
+
+// The scanner reads single bytes with readByte where the grammar needs it
+// and works on the buffer of the reader for the long runs inside strings and
+// skipped values, searching 8 bytes at a time.
+
+const (
+	lsb = 0x0101010101010101
+	msb = 0x8080808080808080
+)
+
+// buffered returns the unread bytes in the reader buffer, filling it when empty.
+// The bytes stay unread until consume.
+func (j *JsonParser) buffered() ([]byte, error) {
+
+	if j.ctx != nil && j.TotalReadSize >= j.ctxCheck { // check now and then while skipping large content
+		j.ctxCheck = j.TotalReadSize + 65536
+		if err := j.ctx.Err(); err != nil {
+			j.readErr = err
+			j.cancelled = true
+			return nil, err
+		}
+	}
+
+	if j.reader.Buffered() == 0 {
+		if _, err := j.reader.Peek(1); err != nil {
+			j.readErr = err
+			return nil, err
+		}
+	}
+	return j.reader.Peek(j.reader.Buffered())
+
+}
+
+// consume moves past buf, the first bytes returned by buffered, keeping the
+// same account readByte keeps for every byte. They can't be unread.
+func (j *JsonParser) consume(buf []byte) {
+
+	n := len(buf)
+	if n == 0 {
+		return
+	}
+
+	if j.tee != nil {
+		j.tee.writeAll(buf)
+	}
+
+	for i := max(n-len(j.recent), 0); i < n; i++ {
+		j.recent[(j.TotalReadSize+uint64(i))%uint64(len(j.recent))] = buf[i]
+	}
+
+	if last := bytes.LastIndexByte(buf, '\n'); last >= 0 {
+		j.line += bytes.Count(buf, []byte{'\n'})
+		if prev := bytes.LastIndexByte(buf[:last], '\n'); prev >= 0 {
+			j.prevColumn = last - prev - 1
+		} else {
+			j.prevColumn = j.column + last
+		}
+		j.column = n - 1 - last
+	} else {
+		j.column += n
+	}
+
+	j.TotalReadSize += uint64(n)
+	j.lastReadSize = n
+	j.reader.Discard(n)
+
+}
+
+// plainString copies the bytes of a string in the reader buffer up to a
+// quote, a backslash or a control character to the scratch buffer
+func (j *JsonParser) plainString() {
+
+	buf, _ := j.reader.Peek(j.reader.Buffered())
+	if n := stringSpan(buf); n > 0 {
+		if !j.zeroCopy {
+			j.scratch.addBytes(buf[:n])
+		}
+		j.consume(buf[:n])
+	}
+
+}
+
+// plainSpace consumes the whitespace at the start of the reader buffer
+func (j *JsonParser) plainSpace() {
+
+	buf, _ := j.reader.Peek(j.reader.Buffered())
+	n := 0
+	for n < len(buf) && isSpace(buf[n]) {
+		n++
+	}
+	j.consume(buf[:n])
+
+}
+
+// plainScalar consumes the bytes of a number, boolean or null in the reader buffer
+func (j *JsonParser) plainScalar() {
+
+	buf, _ := j.reader.Peek(j.reader.Buffered())
+	n := 0
+	for n < len(buf) && !isSpace(buf[n]) && buf[n] != ',' && buf[n] != '}' && buf[n] != ']' {
+		n++
+	}
+	j.consume(buf[:n])
+
+}
+
+// plainNumber copies the bytes of a number in the reader buffer to the
+// scratch buffer, up to its end or the first byte not allowed in state, and
+// returns the state after them
+func (j *JsonParser) plainNumber(state int) int {
+
+	buf, _ := j.reader.Peek(j.reader.Buffered())
+	n := 0
+	for ; n < len(buf); n++ {
+		c := buf[n]
+		if isSpace(c) || c == ',' || c == '}' || c == ']' {
+			break
+		}
+		if !j.lenientNumbers {
+			next := numberStep(state, c)
+			if next == numInvalid {
+				break
+			}
+			state = next
+		}
+	}
+	j.scratch.addBytes(buf[:n])
+	j.consume(buf[:n])
+	return state
+
+}
+
+// copyString moves the string read so far to the scratch buffer when an
+// escape ends zero copy reading, the backslash being the last byte read
+func (j *JsonParser) copyString() {
+
+	if j.zeroCopy {
+		j.scratch.addBytes(j.data[j.strStart : j.TotalReadSize-1])
+		j.zeroCopy = false
+	}
+
+}
+
+// stringValue returns the string read by string, referencing the input when
+// it needed no copy
+func (j *JsonParser) stringValue() string {
+
+	if j.zeroCopy {
+		return unsafe.String(unsafe.SliceData(j.data[j.strStart:]), j.strEnd-j.strStart)
+	}
+	return j.scratch.string()
+
+}
+
+// stringBytes is like stringValue, the bytes are only valid until the next read
+func (j *JsonParser) stringBytes() []byte {
+
+	if j.zeroCopy {
+		return j.data[j.strStart:j.strEnd]
+	}
+	return j.scratch.bytes()
+
+}
+
+// stringSpan returns the index of the first '"', '\\' or control character in b, or len(b)
+func stringSpan(b []byte) int {
+
+	i := 0
+	for ; i+8 <= len(b); i += 8 {
+		x := binary.LittleEndian.Uint64(b[i:])
+		q := x ^ (lsb * '"')
+		s := x ^ (lsb * '\\')
+		m := ((q - lsb) &^ q) | ((s - lsb) &^ s) | ((x - lsb*0x20) &^ x)
+		if m &= msb; m != 0 {
+			return i + bits.TrailingZeros64(m)/8
+		}
+	}
+	for ; i < len(b); i++ {
+		if c := b[i]; c == '"' || c == '\\' || c < 0x20 {
+			return i
+		}
+	}
+	return len(b)
+
+}
+
+// indexAny3 returns the index of the first c1, c2 or c3 in b, or len(b)
+func indexAny3(b []byte, c1, c2, c3 byte) int {
+
+	i := 0
+	for ; i+8 <= len(b); i += 8 {
+		x := binary.LittleEndian.Uint64(b[i:])
+		x1 := x ^ (lsb * uint64(c1))
+		x2 := x ^ (lsb * uint64(c2))
+		x3 := x ^ (lsb * uint64(c3))
+		m := ((x1 - lsb) &^ x1) | ((x2 - lsb) &^ x2) | ((x3 - lsb) &^ x3)
+		if m &= msb; m != 0 {
+			return i + bits.TrailingZeros64(m)/8
+		}
+	}
+	for ; i < len(b); i++ {
+		if c := b[i]; c == c1 || c == c2 || c == c3 {
+			return i
+		}
+	}
+	return len(b)
+
+}
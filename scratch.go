@@ -37,6 +37,15 @@ func (s *scratch) add(c byte) {
 	s.fill++
 }
 
+// append bytes to scratch buffer
+func (s *scratch) addBytes(b []byte) {
+	for s.fill+len(b) >= cap(s.data) {
+		s.grow()
+	}
+
+	s.fill += copy(s.data[s.fill:], b)
+}
+
 // append encoded rune to scratch buffer
 func (s *scratch) addRune(r rune) int {
 	if s.fill+utf8.UTFMax >= cap(s.data) {
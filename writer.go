@@ -0,0 +1,266 @@
+package jsparser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// JSONWriter writes json incrementally, the counterpart of JsonParser. Only
+// the open objects and arrays are kept in memory so results of Stream can be
+// written one by one. Errors are sticky, after the first one every method
+// returns it.
+type JSONWriter struct {
+	w     *bufio.Writer
+	enc   *encoder
+	stack []writerFrame
+	roots int
+	err   error
+}
+
+// writerFrame is an object or array not closed yet
+type writerFrame struct {
+	isArray bool
+	count   int  // properties or elements written
+	key     bool // a property name was written and waits for its value
+}
+
+// NewJSONWriter writes to w through a buffer, call Close or Flush at the end
+func NewJSONWriter(w io.Writer) *JSONWriter {
+
+	bw := bufio.NewWriterSize(w, 65536)
+	return &JSONWriter{w: bw, enc: &encoder{w: bw}}
+
+}
+
+// Indent starts every property and element on a new line like MarshalIndent
+func (w *JSONWriter) Indent(prefix, indent string) *JSONWriter {
+
+	w.enc.prefix = prefix
+	w.enc.indent = indent
+	return w
+
+}
+
+// BeginObject opens an object
+func (w *JSONWriter) BeginObject() error {
+
+	if w.beforeValue() != nil {
+		return w.err
+	}
+	w.w.WriteByte('{')
+	w.stack = append(w.stack, writerFrame{})
+	return nil
+
+}
+
+// EndObject closes the innermost object
+func (w *JSONWriter) EndObject() error {
+	return w.end(false, '}')
+}
+
+// BeginArray opens an array
+func (w *JSONWriter) BeginArray() error {
+
+	if w.beforeValue() != nil {
+		return w.err
+	}
+	w.w.WriteByte('[')
+	w.stack = append(w.stack, writerFrame{isArray: true})
+	return nil
+
+}
+
+// EndArray closes the innermost array
+func (w *JSONWriter) EndArray() error {
+	return w.end(true, ']')
+}
+
+// Key writes the name of the next property of the innermost object
+func (w *JSONWriter) Key(key string) error {
+
+	if w.err != nil {
+		return w.err
+	}
+
+	n := len(w.stack)
+	if n == 0 || w.stack[n-1].isArray || w.stack[n-1].key {
+		return w.fail("Key must be written inside an object, before each value")
+	}
+
+	top := &w.stack[n-1]
+	if top.count > 0 {
+		w.w.WriteByte(',')
+	}
+	w.enc.newline(n)
+	w.enc.string(key)
+	w.w.WriteByte(':')
+	if w.enc.indent != "" || w.enc.prefix != "" {
+		w.w.WriteByte(' ')
+	}
+	top.key = true
+	return nil
+
+}
+
+// Value writes a parsed value, results of Stream and Parse included
+func (w *JSONWriter) Value(v *JSON) error {
+
+	if w.beforeValue() != nil {
+		return w.err
+	}
+	if err := w.enc.node(v, len(w.stack)); err != nil {
+		w.err = err
+	}
+	return w.err
+
+}
+
+// String writes a string value
+func (w *JSONWriter) String(s string) error {
+
+	if w.beforeValue() != nil {
+		return w.err
+	}
+	w.enc.string(s)
+	return nil
+
+}
+
+// Number writes a number value as it is
+func (w *JSONWriter) Number(n json.Number) error {
+
+	if w.err == nil && !validNumber(string(n)) {
+		return w.fail(fmt.Sprintf("Invalid number %q", n))
+	}
+	if w.beforeValue() != nil {
+		return w.err
+	}
+	if err := w.enc.node(&JSON{StringVal: string(n), ValueType: Number}, 0); err != nil {
+		w.err = err
+	}
+	return w.err
+
+}
+
+// Bool writes a boolean value
+func (w *JSONWriter) Bool(b bool) error {
+
+	if w.beforeValue() != nil {
+		return w.err
+	}
+	w.enc.value(b, 0)
+	return nil
+
+}
+
+// Null writes a null value
+func (w *JSONWriter) Null() error {
+
+	if w.beforeValue() != nil {
+		return w.err
+	}
+	w.w.WriteString("null")
+	return nil
+
+}
+
+// WriteAll writes every result received from ch, as elements of the
+// innermost array or as separate lines at the root. The first error result
+// stops writing and is returned.
+func (w *JSONWriter) WriteAll(ch <-chan *JSON) error {
+
+	for res := range ch {
+		if res.Err != nil {
+			if w.err == nil {
+				w.err = res.Err
+			}
+			return w.err
+		}
+		if err := w.Value(res); err != nil {
+			return err
+		}
+	}
+	return w.err
+
+}
+
+// Flush writes buffered data to the underlying writer
+func (w *JSONWriter) Flush() error {
+
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.w.Flush()
+	return w.err
+
+}
+
+// Close checks that every object and array is closed and flushes
+func (w *JSONWriter) Close() error {
+
+	if w.err == nil && len(w.stack) > 0 {
+		return w.fail("Unclosed object or array")
+	}
+	return w.Flush()
+
+}
+
+// beforeValue writes the separator needed before a value
+func (w *JSONWriter) beforeValue() error {
+
+	if w.err != nil {
+		return w.err
+	}
+
+	n := len(w.stack)
+	if n == 0 { // values at the root are written one per line
+		if w.roots > 0 {
+			w.w.WriteByte('\n')
+		}
+		w.roots++
+		return nil
+	}
+
+	top := &w.stack[n-1]
+	if top.isArray {
+		if top.count > 0 {
+			w.w.WriteByte(',')
+		}
+		w.enc.newline(n)
+	} else if !top.key {
+		return w.fail("Value inside an object must follow a Key")
+	}
+	top.key = false
+	top.count++
+	return nil
+
+}
+
+func (w *JSONWriter) end(isArray bool, c byte) error {
+
+	if w.err != nil {
+		return w.err
+	}
+
+	n := len(w.stack)
+	if n == 0 || w.stack[n-1].isArray != isArray || w.stack[n-1].key {
+		return w.fail(fmt.Sprintf("Unexpected '%c'", c))
+	}
+
+	if w.stack[n-1].count > 0 {
+		w.enc.newline(n - 1)
+	}
+	w.w.WriteByte(c)
+	w.stack = w.stack[:n-1]
+	return nil
+
+}
+
+func (w *JSONWriter) fail(msg string) error {
+
+	w.err = fmt.Errorf("JSONWriter: %s", msg)
+	return w.err
+
+}